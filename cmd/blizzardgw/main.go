@@ -1,17 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"flag"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/stepherg/blizzardgw/internal/config"
 	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
 	"github.com/stepherg/blizzardgw/internal/rpc"
 	"github.com/stepherg/blizzardgw/internal/webhook"
 	"github.com/stepherg/blizzardgw/internal/ws"
@@ -30,16 +36,66 @@ func main() {
 	if v := os.Getenv("SCYTALE_AUTH"); v != "" {
 		cfg.ScytaleAuth = v
 	}
+	if v := os.Getenv("ALLOWED_ORIGIN"); v != "" {
+		cfg.AllowedOrigin = v
+	}
+	if v := os.Getenv("RPC_MIDDLEWARE"); v != "" {
+		cfg.Middleware = splitCSV(v)
+	}
+	middleware, err := rpc.MiddlewareByName(cfg.Middleware)
+	if err != nil {
+		log.Fatalf("RPC_MIDDLEWARE: %v", err)
+	}
+
+	// The gateway's own routes. Not http.DefaultServeMux: importing expvar
+	// registers /debug/vars there, which must not be public.
+	mux := http.NewServeMux()
+
+	// Event bus used for async event fanout
+	bus := events.NewBus()
+
+	// Device presence, kept current from Talaria device-status events when
+	// the webhook is signed, and otherwise only from Scytale's answers. It
+	// also fails calls to offline devices fast, probing them periodically.
+	pres := presence.NewTable()
+	pres.ProbeInterval = parseDurationEnv("DEVICE_PROBE_INTERVAL", 30*time.Second)
+	pres.Bus = bus
+	if os.Getenv("WEBHOOK_ENABLE") != "true" || os.Getenv("WEBHOOK_SECRET") == "" {
+		log.Printf("presence: webhook not enabled with WEBHOOK_SECRET; device presence follows Scytale answers only")
+	}
+
+	// Adaptive timeouts: observed per-device, per-method p99 latency times a
+	// multiplier, clamped to a floor and ceiling, replaces the default
+	// timeout once enough calls have completed.
+	var latency *rpc.LatencyTracker
+	if os.Getenv("ADAPTIVE_TIMEOUT") == "true" {
+		latency = &rpc.LatencyTracker{
+			MinSamples: parseIntEnv("ADAPTIVE_TIMEOUT_MIN_SAMPLES", 20),
+			Multiplier: parseFloatEnv("ADAPTIVE_TIMEOUT_MULTIPLIER", 3),
+			Floor:      parseDurationEnv("ADAPTIVE_TIMEOUT_FLOOR", time.Second),
+			Ceiling:    parseDurationEnv("ADAPTIVE_TIMEOUT_CEILING", 60*time.Second),
+		}
+	}
+
+	// Retries: only methods listed in RPC_RETRY_METHODS (globs of methods
+	// safe to repeat) are retried on transport errors and Scytale 5xx.
+	var retry *rpc.RetryPolicy
+	if v := os.Getenv("RPC_RETRY_METHODS"); v != "" {
+		retry = &rpc.RetryPolicy{
+			Methods:     splitCSV(v),
+			MaxAttempts: parseIntEnv("RPC_RETRY_MAX_ATTEMPTS", 3),
+			BaseDelay:   parseDurationEnv("RPC_RETRY_BASE_DELAY", 100*time.Millisecond),
+			MaxDelay:    parseDurationEnv("RPC_RETRY_MAX_DELAY", 2*time.Second),
+			Deadline:    parseDurationEnv("RPC_RETRY_DEADLINE", 0),
+		}
+	}
 
 	var dispatcher rpc.Dispatcher = rpc.EchoDispatcher{}
 	if strings.TrimSpace(cfg.ScytaleURL) != "" {
 		log.Printf("wrp bridging enabled -> %s", cfg.ScytaleURL)
-		dispatcher = &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: cfg.ScytaleURL, Authorization: cfg.ScytaleAuth}, Source: "blizzard/gateway"}
+		dispatcher = &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: cfg.ScytaleURL, Authorization: cfg.ScytaleAuth}, Source: "blizzard/gateway", Gate: pres, Latency: latency, Retry: retry}
 	}
 
-	// Event bus used for async event fanout
-	bus := events.NewBus()
-
 	// Webhook registration (raw Argus)
 	// Apply defaults if not explicitly provided
 	if os.Getenv("WEBHOOK_ENABLE") == "" {
@@ -60,7 +116,8 @@ func main() {
 		os.Setenv("WEBHOOK_URL", "http://blizzardgw:8920/webhook/events")
 	}
 	if os.Getenv("WEBHOOK_EVENTS") == "" {
-		os.Setenv("WEBHOOK_EVENTS", ".*Blizzard.*")
+		// Blizzard device events plus Talaria online/offline for presence.
+		os.Setenv("WEBHOOK_EVENTS", ".*Blizzard.*|device-status/.*")
 	}
 	if os.Getenv("WEBHOOK_DEVICE_MATCH") == "" {
 		os.Setenv("WEBHOOK_DEVICE_MATCH", ".*")
@@ -76,6 +133,7 @@ func main() {
 			Bucket:      os.Getenv("ARGUS_BUCKET"),
 			AuthBasic:   os.Getenv("ARGUS_BASIC_AUTH"),
 			CallbackURL: os.Getenv("WEBHOOK_URL"),
+			Secret:      os.Getenv("WEBHOOK_SECRET"),
 			TTL:         parseIntEnv("WEBHOOK_TTL", 0),
 			Retries:     parseIntEnv("WEBHOOK_MAX_RETRIES", 3),
 		}
@@ -89,22 +147,148 @@ func main() {
 		go func() {
 			whCfg.Register()
 		}()
-		// Register ingestion endpoint
-		http.HandleFunc("/webhook/events", webhook.Handler(bus))
+		// Register ingestion endpoint. Unsigned deliveries could fake
+		// device events, so presence only follows them with a secret.
+		if whCfg.Secret == "" {
+			log.Printf("WEBHOOK_SECRET not set; webhook deliveries are unauthenticated and do not feed presence")
+		}
+		mux.HandleFunc("/webhook/events", webhook.Handler(bus, pres, whCfg.Secret))
+	}
+
+	overflow, err := ws.ParseOverflowPolicy(os.Getenv("WS_OVERFLOW_POLICY"))
+	if err != nil {
+		log.Printf("%v; using %s", err, overflow)
+	}
+
+	overflowCode, err := ws.ParseOverflowCloseCode(os.Getenv("WS_OVERFLOW_CLOSE_CODE"))
+	if err != nil {
+		log.Fatalf("WS_OVERFLOW_CLOSE_CODE: %v", err)
+	}
+
+	proxies, err := ws.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+
+	origins, err := ws.ParseOriginPolicy(cfg.AllowedOrigin)
+	if err != nil {
+		log.Fatalf("ALLOWED_ORIGIN: %v", err)
+	}
+
+	// Device routing: a table file overrides the DEST_* environment defaults.
+	routes := ws.EnvRoutes()
+	if f := os.Getenv("ROUTE_TABLE"); f != "" {
+		if routes, err = ws.LoadRouteTable(f); err != nil {
+			log.Fatalf("load route table: %v", err)
+		}
+		log.Printf("route table loaded from %s (%d rules)", f, len(routes.Rules))
+	}
+
+	// Upstream call timeouts: per-method rules from a policy file, with
+	// RPC_TIMEOUT as the default when the file does not set one.
+	timeouts := &ws.TimeoutPolicy{}
+	if f := os.Getenv("TIMEOUT_POLICY"); f != "" {
+		if timeouts, err = ws.LoadTimeoutPolicy(f); err != nil {
+			log.Fatalf("load timeout policy: %v", err)
+		}
+		log.Printf("timeout policy loaded from %s (%d rules)", f, len(timeouts.Rules))
+	}
+	if timeouts.Default == 0 {
+		timeouts.Default = parseDurationEnv("RPC_TIMEOUT", 8*time.Second)
 	}
 
+	connRate := parseRateEnv("WS_RATE_CONN")
+	principalRate := parseRateEnv("WS_RATE_PRINCIPAL")
+	deviceRate := parseRateEnv("WS_RATE_DEVICE")
+
 	h := &ws.Handler{
-		Upgrader:    websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		Upgrader:    websocket.Upgrader{CheckOrigin: origins.CheckOrigin, Subprotocols: ws.Subprotocols},
 		Dispatcher:  dispatcher,
-		SendBufSize: 64,
+		Routes:      routes,
+		Timeouts:    timeouts,
+		Latency:     latency,
+		SendBufSize: parseIntEnv("WS_SEND_BUFFER", 64),
 		Bus:         bus,
+		Presence:    pres,
+		Middleware:  middleware,
+		MaxInFlight: parseIntEnv("WS_MAX_INFLIGHT", 32),
+
+		OverflowPolicy:    overflow,
+		OverflowCloseCode: overflowCode,
+
+		SessionTTL:    parseDurationEnv("WS_SESSION_TTL", 2*time.Minute),
+		SessionBuffer: parseIntEnv("WS_SESSION_BUFFER", 256),
+
+		MaxDetachedSessions: parseIntEnv("WS_MAX_DETACHED_SESSIONS", 1024),
+		MaxDetachedPerIP:    parseIntEnv("WS_MAX_DETACHED_PER_IP", 16),
+
+		ConnLimits: ws.ConnLimits{
+			Total:     parseIntEnv("WS_MAX_CONNS", 0),
+			PerIP:     parseIntEnv("WS_MAX_CONNS_PER_IP", 0),
+			PerDevice: parseIntEnv("WS_MAX_CONNS_PER_DEVICE", 0),
+		},
+
+		TrustedProxies: proxies,
+
+		ConnRate:      connRate,
+		PrincipalRate: principalRate,
+		DeviceRate:    deviceRate,
+
+		ReverseTimeout: parseDurationEnv("WS_REVERSE_TIMEOUT", 60*time.Second),
 	}
 
 	// Register both exact /ws and prefix /ws/ to allow clients to append /<device>/<service>
-	http.Handle("/", h)
-	http.Handle("/ws", h)
-	log.Printf("blizzard gateway listening on %s", cfg.Listen)
-	log.Fatal(http.ListenAndServe(cfg.Listen, nil))
+	mux.Handle("/", h)
+	mux.Handle("/ws", h)
+
+	// Admin sessions API: list live connections and force-disconnect one.
+	// It lists and closes every client's connection, and /debug/vars exposes
+	// the command line and memory stats, so both are only mounted when
+	// ADMIN_TOKEN is set.
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		admin := ws.AdminHandler(h.Registry(), token)
+		mux.Handle("/admin/sessions", admin)
+		mux.Handle("/admin/sessions/", admin)
+		mux.Handle("/debug/vars", ws.AdminOnly(token, expvar.Handler()))
+	} else {
+		log.Printf("ADMIN_TOKEN not set; admin sessions API and /debug/vars disabled")
+	}
+
+	// Device-initiated requests (WRP SimpleRequestResponse) relayed to clients.
+	// Callers pick the device a client is asked on behalf of, so the endpoint
+	// is only mounted with its own credential, REVERSE_AUTH, never with
+	// SCYTALE_AUTH and its well-known default.
+	if reverseAuth := os.Getenv("REVERSE_AUTH"); reverseAuth != "" {
+		mux.HandleFunc("/wrp/requests", ws.ReverseHandler(h, rpc.AuthorizationHeader(reverseAuth)))
+	} else {
+		log.Printf("REVERSE_AUTH not set; reverse requests disabled")
+	}
+	srv := &http.Server{Addr: cfg.Listen, Handler: mux}
+	go func() {
+		log.Printf("blizzard gateway listening on %s", cfg.Listen)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// On SIGTERM/SIGINT drain WebSocket sessions (refuse upgrades, notify
+	// clients, let in-flight requests finish) before stopping the listener.
+	sig, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-sig.Done()
+	drain := parseDurationEnv("WS_DRAIN_TIMEOUT", 30*time.Second)
+	log.Printf("shutting down; draining %d connections (timeout %s)", h.Registry().Len(), drain)
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := h.Shutdown(ctx); err != nil {
+		log.Printf("drain incomplete: %v", err)
+	}
+	// HTTP shutdown gets its own grace period: draining may have used up ctx.
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), parseDurationEnv("HTTP_SHUTDOWN_TIMEOUT", 10*time.Second))
+	defer httpCancel()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
 }
 
 func splitCSV(s string) []string {
@@ -130,6 +314,39 @@ func parseIntEnv(key string, def int) int {
 	return i
 }
 
+func parseDurationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func parseFloatEnv(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// parseRateEnv reads a ws.ParseRateLimit spec; invalid values disable the limit.
+func parseRateEnv(key string) ws.RateLimit {
+	l, err := ws.ParseRateLimit(os.Getenv(key))
+	if err != nil {
+		log.Printf("%s: %v; limit disabled", key, err)
+	}
+	return l
+}
+
 // ensure main doesn't exit immediately if webhook register needs brief time (optional small sleep for logs in ephemeral env)
 func init() {
 	time.Sleep(10 * time.Millisecond)
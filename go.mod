@@ -7,7 +7,9 @@ toolchain go1.24.7
 require (
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/websocket v1.5.3
+	github.com/ugorji/go/codec v1.2.12
 	github.com/xmidt-org/ancla v0.4.0
+	github.com/xmidt-org/webhook-schema v0.1.1-0.20250408163841-a0762984a7fb
 	github.com/xmidt-org/wrp-go/v3 v3.7.0
 )
 
@@ -26,11 +28,9 @@ require (
 	github.com/prometheus/procfs v0.15.1 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
-	github.com/ugorji/go/codec v1.2.12 // indirect
 	github.com/xmidt-org/httpaux v0.4.1 // indirect
 	github.com/xmidt-org/touchstone v0.1.7 // indirect
 	github.com/xmidt-org/urlegit v0.1.28 // indirect
-	github.com/xmidt-org/webhook-schema v0.1.1-0.20250408163841-a0762984a7fb // indirect
 	go.uber.org/dig v1.18.0 // indirect
 	go.uber.org/fx v1.23.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
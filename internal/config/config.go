@@ -10,11 +10,14 @@ type Config struct {
 	ReadTimeout   time.Duration `json:"read_timeout"`
 	WriteTimeout  time.Duration `json:"write_timeout"`
 	IdleTimeout   time.Duration `json:"idle_timeout"`
-	AllowedOrigin string        `json:"allowed_origin"`
+	AllowedOrigin string        `json:"allowed_origin"` // comma list; see ws.ParseOriginPolicy (empty = same-host)
 
 	// Optional upstream WRP/Scytale endpoint for future forwarding.
 	ScytaleURL  string `json:"scytale_url"`
 	ScytaleAuth string `json:"scytale_auth"`
+
+	// Dispatcher middleware names, outermost first (see rpc.MiddlewareByName).
+	Middleware []string `json:"middleware"`
 }
 
 func Default() Config {
@@ -26,5 +29,6 @@ func Default() Config {
 		// Defaults added: local Scytale test endpoint & basic auth token (base64 of user:pass)
 		ScytaleURL:  "http://scytale:6300/api/v2/device", // assumed http scheme for provided host:port
 		ScytaleAuth: "dXNlcjpwYXNz",
+		Middleware:  []string{"recover"},
 	}
 }
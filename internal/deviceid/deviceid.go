@@ -0,0 +1,100 @@
+// Package deviceid canonicalizes WRP device identifiers so the same device
+// compares equal however an operator or upstream spells it. Parsing is
+// delegated to wrp-go; this package adds default schemes for bare values.
+package deviceid
+
+import (
+	"errors"
+	"strings"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+// WRP identifier schemes.
+const (
+	SchemeMAC    = wrp.SchemeMAC
+	SchemeUUID   = wrp.SchemeUUID
+	SchemeSerial = wrp.SchemeSerial
+	SchemeEvent  = wrp.SchemeEvent
+	SchemeDNS    = wrp.SchemeDNS
+)
+
+var (
+	// ErrInvalid is returned for identifiers that cannot be canonicalized,
+	// e.g. a MAC with non-hex digits or an empty value.
+	ErrInvalid = errors.New("invalid device id")
+	// ErrNoScheme is returned for a bare value when no default scheme applies.
+	ErrNoScheme = errors.New("device id has no scheme")
+)
+
+// ID is a canonical device identifier.
+type ID struct {
+	Scheme string // lower-case scheme without the colon
+	Value  string // canonical value for the scheme
+}
+
+// String returns the "scheme:value" form.
+func (id ID) String() string { return id.Scheme + ":" + id.Value }
+
+// Locator returns the "scheme:value/service" destination for id.
+func (id ID) Locator(service string) string {
+	if service == "" {
+		return id.String()
+	}
+	return id.String() + "/" + service
+}
+
+// Parse canonicalizes s with wrp.ParseDeviceID, accepting the mac:, uuid:,
+// serial: and dns: forms with a case-insensitive scheme; MACs lose their
+// separators and are lower-cased, other values are kept as given. A value
+// without a recognized scheme is read as defaultScheme, so "AA:BB:CC:DD:EE:FF"
+// parses as a MAC when defaultScheme is "mac"; with no default it fails with
+// ErrNoScheme. Any "/service" suffix is dropped.
+//
+// wrp-go does not treat event: names as device ids; they are accepted here
+// and kept as given so event destinations pass through unchanged.
+func Parse(s, defaultScheme string) (ID, error) {
+	s = strings.TrimSpace(s)
+	scheme, value, ok := strings.Cut(s, ":")
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if !ok || !known(scheme) {
+		if defaultScheme == "" {
+			return ID{}, ErrNoScheme
+		}
+		scheme, value = strings.ToLower(defaultScheme), s
+	}
+	if scheme == SchemeEvent {
+		if value = strings.TrimSpace(value); value == "" {
+			return ID{}, ErrInvalid
+		}
+		return ID{Scheme: scheme, Value: value}, nil
+	}
+	id, err := wrp.ParseDeviceID(scheme + ":" + strings.TrimSpace(value))
+	if err != nil {
+		return ID{}, ErrInvalid
+	}
+	return ID{Scheme: id.Prefix(), Value: id.ID()}, nil
+}
+
+// Normalize returns the canonical "scheme:value" form of s, or s trimmed of
+// surrounding space when it cannot be parsed.
+func Normalize(s, defaultScheme string) string {
+	id, err := Parse(s, defaultScheme)
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	return id.String()
+}
+
+// SchemeOf returns the scheme named by a destination prefix such as "mac:".
+func SchemeOf(prefix string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(prefix), ":"))
+}
+
+func known(scheme string) bool {
+	switch scheme {
+	case SchemeMAC, SchemeUUID, SchemeSerial, SchemeEvent, SchemeDNS:
+		return true
+	}
+	return false
+}
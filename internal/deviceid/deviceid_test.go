@@ -0,0 +1,74 @@
+package deviceid
+
+import (
+	"strings"
+	"testing"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		def     string
+		want    string
+		wantErr error
+	}{
+		{"mac:112233445566", "", "mac:112233445566", nil},
+		{"MAC:AABBCCDDEEFF", "", "mac:aabbccddeeff", nil},
+		{"AA:BB:CC:DD:EE:FF", "mac", "mac:aabbccddeeff", nil},
+		{"aa-bb-cc-dd-ee-ff", "mac", "mac:aabbccddeeff", nil},
+		{"aabb.ccdd.eeff", "mac", "mac:aabbccddeeff", nil},
+		{" mac:aabbccddeeff ", "", "mac:aabbccddeeff", nil},
+		{"serial:AB/12", "", "serial:AB", nil},
+		{"serial:AbC123", "mac", "serial:AbC123", nil},
+		{"UUID:0F1E2D3C-4B5A-6978-8796-A5B4C3D2E1F0", "", "uuid:0F1E2D3C-4B5A-6978-8796-A5B4C3D2E1F0", nil},
+		{"event:device-status", "", "event:device-status", nil},
+		{"dns:Gateway.Example.com", "", "dns:Gateway.Example.com", nil},
+		{"Mac:AaBbCcDdEeFf", "serial", "mac:aabbccddeeff", nil},
+		{"AaBb.CcDd.EeFf", "MAC", "mac:aabbccddeeff", nil},
+		{"aa bb cc dd ee ff", "mac", "", ErrInvalid},
+		{"X1234", "serial", "serial:X1234", nil},
+		{"aabbccddeeff", "", "", ErrNoScheme},
+		{"mac:aabbccddeeXX", "", "", ErrInvalid},
+		{"mac:aabbcc", "", "", ErrInvalid},
+		{"serial:", "", "", ErrInvalid},
+	}
+	for _, tt := range tests {
+		id, err := Parse(tt.in, tt.def)
+		if err != tt.wantErr {
+			t.Errorf("Parse(%q, %q) error = %v, want %v", tt.in, tt.def, err, tt.wantErr)
+			continue
+		}
+		if err == nil && id.String() != tt.want {
+			t.Errorf("Parse(%q, %q) = %q, want %q", tt.in, tt.def, id.String(), tt.want)
+		}
+	}
+}
+
+func TestLocator(t *testing.T) {
+	id, _ := Parse("AA:BB:CC:DD:EE:FF", SchemeOf("MAC:"))
+	if got := id.Locator("BlizzardRDK"); got != "mac:aabbccddeeff/BlizzardRDK" {
+		t.Fatalf("Locator = %q", got)
+	}
+}
+
+// TestParseMatchesWRP checks that every id wrp-go accepts canonicalizes to
+// the same string here, with and without a default scheme for bare values.
+func TestParseMatchesWRP(t *testing.T) {
+	for _, in := range []string{"mac:AA-BB-CC-DD-EE-FF", "MAC:aabbccddeeff/config", "uuid:AbC-123", "Serial:X1", "dns:Host.Example"} {
+		want, err := wrp.ParseDeviceID(in)
+		if err != nil {
+			t.Fatalf("wrp.ParseDeviceID(%q): %v", in, err)
+		}
+		id, err := Parse(in, "")
+		if err != nil || id.String() != string(want) {
+			t.Errorf("Parse(%q) = %q, %v; wrp-go gives %q", in, id.String(), err, want)
+		}
+		_, value, _ := strings.Cut(in, ":")
+		value, _, _ = strings.Cut(value, "/")
+		if id, err := Parse(value, want.Prefix()); err != nil || id.String() != string(want) {
+			t.Errorf("Parse(%q, %q) = %q, %v; want %q", value, want.Prefix(), id.String(), err, want)
+		}
+	}
+}
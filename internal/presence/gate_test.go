@@ -0,0 +1,98 @@
+package presence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// deviceServer is a mock Scytale whose answer is chosen per call by mode:
+// "ok" replies, "hang" waits for the request to be abandoned and "gone"
+// answers 404 (device not connected).
+func deviceServer(t *testing.T, mode *atomic.Value, hits *atomic.Int32) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body) // lets the server notice the client going away
+		switch mode.Load() {
+		case "hang":
+			<-r.Context().Done()
+			return
+		case "gone":
+			http.Error(w, "device not found", http.StatusNotFound)
+			return
+		}
+		payload, _ := json.Marshal(rpc.Response{JSONRPC: "2.0", ID: json.RawMessage(`1`), Result: true})
+		var buf bytes.Buffer
+		_ = wrp.NewEncoder(&buf, wrp.Msgpack).Encode(&wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Payload: payload})
+		_, _ = w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestGateFailsInFlightCallsFast(t *testing.T) {
+	var mode atomic.Value
+	var hits atomic.Int32
+	mode.Store("hang")
+	srv := deviceServer(t, &mode, &hits)
+	tbl := NewTable()
+	d := &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: srv.URL}, Dest: "mac:112233445566/BlizzardRDK", Gate: tbl}
+	req := &rpc.Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"}
+
+	done := make(chan *rpc.Response, 1)
+	go func() { done <- d.Handle(context.Background(), req) }()
+	for hits.Load() == 0 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	tbl.Update(Status{Device: "mac:112233445566", LastSeen: time.Now(), Reason: "ping miss"})
+	select {
+	case resp := <-done:
+		if resp.Error == nil || resp.Error.Code != rpc.CodeDeviceOffline {
+			t.Fatalf("expected device offline error, got %+v", resp)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("in-flight call not ended by offline event")
+	}
+}
+
+func TestGateShortCircuitsAndProbes(t *testing.T) {
+	var mode atomic.Value
+	var hits atomic.Int32
+	mode.Store("gone")
+	srv := deviceServer(t, &mode, &hits)
+	tbl := NewTable()
+	tbl.ProbeInterval = 100 * time.Millisecond
+	d := &rpc.MultiServiceDispatcher{Client: &rpc.WRPClient{URL: srv.URL}, DeviceID: "112233445566", DestPrefix: "mac:", Services: []string{"BlizzardRDK", "config"}, Gate: tbl}
+	req := &rpc.Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"}
+
+	// Scytale's 404 marks the device offline without trying fallback services.
+	if resp := d.Handle(context.Background(), req); resp.Error == nil || resp.Error.Code != rpc.CodeDeviceOffline || hits.Load() != 1 {
+		t.Fatalf("expected device offline after one attempt, got %+v (hits=%d)", resp, hits.Load())
+	}
+	if s, _ := tbl.Get("mac:112233445566"); s.Online || s.Reason != reasonNotConnected {
+		t.Fatalf("expected offline status, got %+v", s)
+	}
+	if resp := d.Handle(context.Background(), req); resp.Error == nil || resp.Error.Code != rpc.CodeDeviceOffline || hits.Load() != 1 {
+		t.Fatalf("expected short-circuit without an upstream call, got %+v (hits=%d)", resp, hits.Load())
+	}
+
+	// After the probe interval one call gets through; its success brings the device back.
+	mode.Store("ok")
+	time.Sleep(tbl.ProbeInterval)
+	if resp := d.Handle(context.Background(), req); resp.Error != nil || hits.Load() != 2 {
+		t.Fatalf("expected probe to succeed, got %+v (hits=%d)", resp, hits.Load())
+	}
+	if s, _ := tbl.Get("mac:112233445566"); !s.Online {
+		t.Fatalf("expected device online after probe, got %+v", s)
+	}
+}
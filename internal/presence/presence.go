@@ -0,0 +1,270 @@
+// Package presence tracks which devices are connected to XMiDT, fed by the
+// device-status online/offline events Talaria emits on connect and disconnect
+// (handed to Table.Update by the webhook) and by calls' outcomes. Its Table
+// doubles as the dispatchers' rpc.DeviceGate, failing calls to offline
+// devices fast.
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// Event names (and notification methods) under which status changes are
+// published on the bus. Only the Table publishes them; the webhook refuses
+// device events using the reserved gateway.* names.
+const (
+	DeviceOnline  = "gateway.device.online"
+	DeviceOffline = "gateway.device.offline"
+)
+
+// statusDestPrefix starts the destination of Talaria device-status events,
+// e.g. "event:device-status/mac:112233445566/offline".
+const statusDestPrefix = "event:device-status/"
+
+// Status is what is known about one device's connection to XMiDT.
+type Status struct {
+	Device    string    `json:"device"`
+	Online    bool      `json:"online"`
+	LastSeen  time.Time `json:"lastSeen,omitzero"`
+	SessionID string    `json:"sessionId,omitempty"` // Talaria session of the last connect/disconnect
+	Reason    string    `json:"reason,omitempty"`    // reason for closure when offline
+}
+
+// IsStatusEvent reports whether ev is a published status change.
+func IsStatusEvent(ev events.Event) bool {
+	return ev.Name == DeviceOnline || ev.Name == DeviceOffline
+}
+
+// ParseStatusEvent decodes a Talaria device-status online/offline WRP event.
+// ok is false for any other message, and for one whose source is not the
+// device it reports on. Missing timestamps default to now and a missing
+// session id to the message's.
+func ParseStatusEvent(msg *wrp.Message) (s Status, ok bool) {
+	if !strings.HasPrefix(msg.Destination, statusDestPrefix) {
+		return Status{}, false
+	}
+	parts := strings.Split(strings.TrimPrefix(msg.Destination, statusDestPrefix), "/")
+	if len(parts) < 2 {
+		return Status{}, false
+	}
+	switch parts[len(parts)-1] {
+	case "online":
+		s.Online = true
+	case "offline":
+	default:
+		return Status{}, false
+	}
+	var p struct {
+		ID        string `json:"id"`
+		TS        string `json:"ts"`
+		SessionID string `json:"session-id"`
+		Reason    string `json:"reason-for-closure"`
+	}
+	_ = json.Unmarshal(msg.Payload, &p)
+	s.Device = canonical(parts[0])
+	if s.Device == "" {
+		s.Device = canonical(p.ID)
+	}
+	if s.Device == "" {
+		return Status{}, false
+	}
+	if source, _, _ := strings.Cut(msg.Source, "/"); key(source) != key(s.Device) {
+		return Status{}, false
+	}
+	s.SessionID, s.Reason = p.SessionID, p.Reason
+	if s.SessionID == "" {
+		s.SessionID = msg.SessionID
+	}
+	if s.Online {
+		s.Reason = ""
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, p.TS); err == nil {
+		s.LastSeen = ts
+	} else {
+		s.LastSeen = time.Now()
+	}
+	return s, true
+}
+
+// Event returns the bus event announcing s. Its payload is the ready-made
+// gateway.device.online/offline JSON-RPC notification.
+func (s Status) Event() events.Event {
+	name := DeviceOffline
+	if s.Online {
+		name = DeviceOnline
+	}
+	payload, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "method": name, "params": s})
+	return events.Event{Device: s.Device, Name: name, Payload: payload}
+}
+
+// defaultProbeInterval applies when Table.ProbeInterval is unset.
+const defaultProbeInterval = 30 * time.Second
+
+// reasonNotConnected is recorded when Scytale reports the device missing.
+const reasonNotConnected = "device not connected"
+
+// Table is the presence table, keyed by canonical device id.
+type Table struct {
+	// ProbeInterval is how often one call is let through to a device marked
+	// offline, in case its return was missed (default 30s). Set before use.
+	ProbeInterval time.Duration
+	// Bus, when set, is where status changes are published as
+	// gateway.device.online/offline events. Set before use.
+	Bus *events.Bus
+
+	mu      sync.RWMutex
+	devices map[string]Status
+	calls   map[string]map[*gateCall]struct{} // in-flight calls per device
+	probed  map[string]time.Time              // last probe of an offline device
+}
+
+type gateCall struct{ cancel context.CancelCauseFunc }
+
+func NewTable() *Table {
+	return &Table{devices: make(map[string]Status), calls: make(map[string]map[*gateCall]struct{}), probed: make(map[string]time.Time)}
+}
+
+// Get returns the status of device; see canonical for how ids are matched.
+func (t *Table) Get(device string) (Status, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	s, ok := t.devices[key(device)]
+	return s, ok
+}
+
+// Update records s, typically from ParseStatusEvent, and publishes it unless
+// the table already holds a newer status for the device (events can arrive
+// out of order). A LastSeen in the future is clamped to now, so no status can
+// shadow the ones after it. Going offline ends the device's in-flight calls.
+func (t *Table) Update(s Status) {
+	k := key(s.Device)
+	if k == "" {
+		return
+	}
+	now := time.Now()
+	if s.LastSeen.IsZero() || s.LastSeen.After(now) {
+		s.LastSeen = now
+	}
+	t.mu.Lock()
+	if cur, ok := t.devices[k]; ok && cur.LastSeen.After(s.LastSeen) {
+		t.mu.Unlock()
+		return
+	}
+	t.devices[k] = s
+	if s.Online {
+		delete(t.probed, k)
+	} else {
+		t.probed[k] = now // first probe one interval from now
+		t.cancelCalls(k)
+	}
+	t.mu.Unlock()
+	if t.Bus != nil {
+		t.Bus.Publish(s.Event())
+	}
+}
+
+// Seen implements rpc.DeviceGate: the device just answered or sent an event,
+// so it is online as of now.
+func (t *Table) Seen(device string) {
+	k := key(device)
+	if k == "" {
+		return
+	}
+	t.mu.Lock()
+	cur, known := t.devices[k]
+	s := Status{Device: canonical(device), Online: true, LastSeen: time.Now(), SessionID: cur.SessionID}
+	t.devices[k] = s
+	delete(t.probed, k)
+	t.mu.Unlock()
+	if known && !cur.Online && t.Bus != nil {
+		t.Bus.Publish(s.Event())
+	}
+}
+
+// Offline implements rpc.DeviceGate: Scytale reported the device not
+// connected. In-flight calls to it end with rpc.ErrDeviceOffline and new ones
+// are refused until it is seen again or a probe gets through.
+func (t *Table) Offline(device string) {
+	k := key(device)
+	if k == "" {
+		return
+	}
+	t.mu.Lock()
+	cur, known := t.devices[k]
+	changed := !known || cur.Online
+	s := Status{Device: canonical(device), LastSeen: time.Now(), SessionID: cur.SessionID, Reason: reasonNotConnected}
+	if changed {
+		t.devices[k] = s
+		t.probed[k] = s.LastSeen
+	}
+	t.cancelCalls(k)
+	t.mu.Unlock()
+	if changed && t.Bus != nil {
+		t.Bus.Publish(s.Event())
+	}
+}
+
+// Begin implements rpc.DeviceGate. Calls to an offline device are refused,
+// except one probe per ProbeInterval.
+func (t *Table) Begin(parent context.Context, device string) (context.Context, func(), bool) {
+	k := key(device)
+	if k == "" {
+		return parent, func() {}, true
+	}
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.devices[k]; ok && !s.Online {
+		interval := t.ProbeInterval
+		if interval <= 0 {
+			interval = defaultProbeInterval
+		}
+		if now.Sub(t.probed[k]) < interval {
+			return parent, func() {}, false
+		}
+		t.probed[k] = now
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	c := &gateCall{cancel: cancel}
+	if t.calls[k] == nil {
+		t.calls[k] = make(map[*gateCall]struct{})
+	}
+	t.calls[k][c] = struct{}{}
+	return ctx, func() {
+		t.mu.Lock()
+		delete(t.calls[k], c)
+		if len(t.calls[k]) == 0 {
+			delete(t.calls, k)
+		}
+		t.mu.Unlock()
+		cancel(nil)
+	}, true
+}
+
+// cancelCalls ends device k's in-flight calls; t.mu must be held.
+func (t *Table) cancelCalls(k string) {
+	for c := range t.calls[k] {
+		c.cancel(rpc.ErrDeviceOffline)
+	}
+}
+
+// canonical normalizes device with deviceid, reading a bare id as a MAC (the
+// scheme Talaria uses) so "aabbccddeeff" and "mac:AA:BB:CC:DD:EE:FF" share
+// one entry.
+func canonical(device string) string {
+	return deviceid.Normalize(device, deviceid.SchemeMAC)
+}
+
+func key(device string) string {
+	return strings.ToLower(canonical(device))
+}
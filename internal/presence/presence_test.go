@@ -0,0 +1,83 @@
+package presence
+
+import (
+	"testing"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+)
+
+func TestParseStatusEvent(t *testing.T) {
+	tests := []struct {
+		name   string
+		msg    wrp.Message
+		ok     bool
+		expect Status
+	}{
+		{"online", wrp.Message{Source: "mac:112233445566", Destination: "event:device-status/mac:112233445566/online", Payload: []byte(`{"id":"mac:112233445566","ts":"2026-01-02T03:04:05Z","session-id":"s1"}`)},
+			true, Status{Device: "mac:112233445566", Online: true, LastSeen: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), SessionID: "s1"}},
+		{"offline with reason", wrp.Message{Source: "mac:112233445566/status", Destination: "event:device-status/MAC:11:22:33:44:55:66/offline", SessionID: "s2", Payload: []byte(`{"ts":"2026-01-02T03:04:05Z","reason-for-closure":"ping miss"}`)},
+			true, Status{Device: "mac:112233445566", LastSeen: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), SessionID: "s2", Reason: "ping miss"}},
+		{"other device's source", wrp.Message{Source: "mac:aabbccddeeff", Destination: "event:device-status/mac:112233445566/offline"}, false, Status{}},
+		{"other status event", wrp.Message{Destination: "event:device-status/mac:112233445566/unknown"}, false, Status{}},
+		{"device event", wrp.Message{Destination: "event:Blizzard/Time/TimerElapsed"}, false, Status{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := ParseStatusEvent(&tt.msg)
+			if ok != tt.ok || s != tt.expect {
+				t.Errorf("ParseStatusEvent() = %+v, %v; want %+v, %v", s, ok, tt.expect, tt.ok)
+			}
+		})
+	}
+}
+
+func TestTableUpdate(t *testing.T) {
+	bus := events.NewBus()
+	_, sub, cancel := bus.Subscribe(4)
+	defer cancel()
+	tbl := NewTable()
+	tbl.Bus = bus
+
+	t0 := time.Now().Add(-time.Minute)
+	tbl.Update(Status{Device: "mac:112233445566", LastSeen: t0, Reason: "ping miss"})
+	// A stale online status must not override the newer offline one.
+	tbl.Update(Status{Device: "mac:112233445566", Online: true, LastSeen: t0.Add(-time.Second)})
+	if s, ok := tbl.Get("MAC:11-22-33-44-55-66"); !ok || s.Online || s.Reason != "ping miss" {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if ev := <-sub; ev.Name != DeviceOffline || ev.Device != "mac:112233445566" {
+		t.Fatalf("expected offline event, got %+v", ev)
+	}
+	select {
+	case ev := <-sub:
+		t.Fatalf("stale status published: %+v", ev)
+	default:
+	}
+
+	// A status from the future is clamped, so it can't shadow the next one.
+	tbl.Update(Status{Device: "mac:112233445566", Online: true, LastSeen: time.Now().Add(time.Hour)})
+	if s, _ := tbl.Get("mac:112233445566"); s.LastSeen.After(time.Now()) {
+		t.Fatalf("LastSeen not clamped: %v", s.LastSeen)
+	}
+	tbl.Update(Status{Device: "mac:112233445566", LastSeen: time.Now()})
+	if s, _ := tbl.Get("mac:112233445566"); s.Online {
+		t.Fatal("future status shadowed a later one")
+	}
+}
+
+func TestTableCanonicalizesBareIDs(t *testing.T) {
+	tbl := NewTable()
+	tbl.Offline("AA:BB:CC:DD:EE:FF")
+	for _, device := range []string{"aabbccddeeff", "mac:aabbccddeeff", "MAC:AA-BB-CC-DD-EE-FF"} {
+		if s, ok := tbl.Get(device); !ok || s.Online || s.Device != "mac:aabbccddeeff" {
+			t.Errorf("Get(%q) = %+v, %v", device, s, ok)
+		}
+	}
+	tbl.Seen("aabbccddeeff")
+	if s, _ := tbl.Get("mac:AABBCCDDEEFF"); !s.Online {
+		t.Fatalf("bare id update not applied to the canonical entry: %+v", s)
+	}
+}
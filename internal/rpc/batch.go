@@ -0,0 +1,86 @@
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrEmptyBatch is returned by ParseBatch for "[]" (invalid per JSON-RPC 2.0).
+	ErrEmptyBatch = errors.New("empty batch")
+)
+
+// IsBatch reports whether raw looks like a JSON-RPC batch (a JSON array).
+func IsBatch(raw []byte) bool {
+	trimmed := bytes.TrimLeft(raw, " \t\r\n")
+	return len(trimmed) > 0 && trimmed[0] == '['
+}
+
+// ParseBatch splits a batch array into its raw items. Items are validated
+// individually by HandleBatch so one malformed entry does not fail the batch.
+func ParseBatch(raw []byte) ([]json.RawMessage, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrEmptyBatch
+	}
+	return items, nil
+}
+
+// IsNotification reports whether the request carries no id (no response expected).
+func (r *Request) IsNotification() bool {
+	return len(r.ID) == 0
+}
+
+// HandleBatch dispatches batch items concurrently through handle and returns
+// the responses in input order. Invalid items yield an invalid-request error
+// with a null id; notifications are dispatched but produce no entry. The
+// returned slice is empty when every item was a notification.
+func HandleBatch(items []json.RawMessage, handle func(*Request) *Response) []*Response {
+	return HandleBatchLimit(items, 0, handle)
+}
+
+// HandleBatchLimit is HandleBatch running at most limit items at a time
+// (no bound when limit <= 0).
+func HandleBatchLimit(items []json.RawMessage, limit int, handle func(*Request) *Response) []*Response {
+	out := make([]*Response, len(items))
+	var sem chan struct{}
+	if limit > 0 {
+		sem = make(chan struct{}, limit)
+	}
+	var wg sync.WaitGroup
+	for i, item := range items {
+		req, err := ParseRequest(item)
+		if err != nil {
+			out[i] = &Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: -32600, Message: "invalid request", Data: err.Error()}}
+			continue
+		}
+		if sem != nil {
+			sem <- struct{}{}
+		}
+		wg.Add(1)
+		go func(i int, req *Request) {
+			defer wg.Done()
+			if sem != nil {
+				defer func() { <-sem }()
+			}
+			resp := handle(req)
+			if req.IsNotification() {
+				return
+			}
+			out[i] = resp
+		}(i, req)
+	}
+	wg.Wait()
+	resps := make([]*Response, 0, len(out))
+	for _, r := range out {
+		if r != nil {
+			resps = append(resps, r)
+		}
+	}
+	return resps
+}
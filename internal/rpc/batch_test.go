@@ -0,0 +1,88 @@
+package rpc
+
+import (
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseBatch(t *testing.T) {
+	if !IsBatch([]byte(" \n[{}]")) {
+		t.Fatalf("expected leading whitespace array to be a batch")
+	}
+	if IsBatch([]byte(`{"jsonrpc":"2.0"}`)) {
+		t.Fatalf("object must not be treated as batch")
+	}
+	if _, err := ParseBatch([]byte(`[]`)); !errors.Is(err, ErrEmptyBatch) {
+		t.Fatalf("expected ErrEmptyBatch, got %v", err)
+	}
+	if _, err := ParseBatch([]byte(`[{"jsonrpc"`)); err == nil {
+		t.Fatalf("expected parse error for truncated batch")
+	}
+}
+
+func TestHandleBatch(t *testing.T) {
+	items, err := ParseBatch([]byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"A"},
+		{"jsonrpc":"2.0","method":"Notify"},
+		{"foo":"bar"},
+		{"jsonrpc":"2.0","id":"x","method":"B"}
+	]`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	var notified bool
+	resps := HandleBatch(items, func(r *Request) *Response {
+		if r.Method == "Notify" {
+			notified = true
+		}
+		return &Response{JSONRPC: "2.0", ID: r.ID, Result: r.Method}
+	})
+	if !notified {
+		t.Fatalf("notification was not dispatched")
+	}
+	if len(resps) != 3 {
+		t.Fatalf("expected 3 responses (notification omitted), got %d", len(resps))
+	}
+	if string(resps[0].ID) != "1" || resps[0].Result != "A" {
+		t.Fatalf("unexpected first response: %+v", resps[0])
+	}
+	if resps[1].Error == nil || resps[1].Error.Code != -32600 || string(resps[1].ID) != "null" {
+		t.Fatalf("expected invalid request with null id, got %+v", resps[1])
+	}
+	if string(resps[2].ID) != `"x"` {
+		t.Fatalf("unexpected third response: %+v", resps[2])
+	}
+}
+
+func TestHandleBatchAllNotifications(t *testing.T) {
+	items := []json.RawMessage{json.RawMessage(`{"jsonrpc":"2.0","method":"N"}`)}
+	if resps := HandleBatch(items, func(r *Request) *Response { return &Response{JSONRPC: "2.0"} }); len(resps) != 0 {
+		t.Fatalf("expected no responses, got %d", len(resps))
+	}
+}
+
+func TestHandleBatchLimit(t *testing.T) {
+	items := make([]json.RawMessage, 8)
+	for i := range items {
+		items[i] = json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"m"}`)
+	}
+	var running, peak atomic.Int32
+	resps := HandleBatchLimit(items, 3, func(r *Request) *Response {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return &Response{JSONRPC: "2.0", ID: r.ID}
+	})
+	if len(resps) != len(items) || peak.Load() > 3 {
+		t.Fatalf("got %d responses with peak concurrency %d", len(resps), peak.Load())
+	}
+}
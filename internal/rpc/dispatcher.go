@@ -1,6 +1,7 @@
 package rpc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"time"
@@ -36,21 +37,68 @@ type Notification struct {
 	Params  interface{} `json:"params,omitempty"`
 }
 
-// Dispatcher processes JSON-RPC requests.
+// defaultTimeout bounds an upstream call when neither the caller's context
+// nor the dispatcher sets a deadline.
+const defaultTimeout = 8 * time.Second
+
+// Gateway-injected error codes occupy the reserved range -32100 .. -32199.
+const (
+	CodeTransportError  = -32100 // upstream WRP/Scytale failure
+	CodeTooManyInFlight = -32102 // per-connection in-flight limit exceeded
+	CodeRequestCanceled = -32103 // client cancelled the request ($/cancelRequest)
+	CodeShuttingDown    = -32104 // gateway draining; request not accepted
+	CodeRateLimited     = -32105 // connection, principal or device rate limit exceeded
+	CodeNoClient        = -32106 // reverse request: no connected client can take it
+	CodeClientTimeout   = -32107 // reverse request: client did not answer in time
+	CodeDeviceOffline   = -32108 // device is not connected to XMiDT
+	CodeUnauthorized    = -32109 // refused by an Authorize hook
+)
+
+// Dispatcher processes JSON-RPC requests. ctx carries the caller's deadline,
+// cancellation and request-scoped values (e.g. auth claims, trace spans);
+// implementations stop upstream work once it is done.
 type Dispatcher interface {
+	Handle(ctx context.Context, r *Request) *Response
+}
+
+// DispatcherFunc adapts an ordinary function to Dispatcher.
+type DispatcherFunc func(context.Context, *Request) *Response
+
+// Handle implements Dispatcher.
+func (f DispatcherFunc) Handle(ctx context.Context, r *Request) *Response { return f(ctx, r) }
+
+// LegacyDispatcher is the original context-free dispatcher contract.
+type LegacyDispatcher interface {
 	Handle(*Request) *Response
 }
 
+// Legacy adapts a context-free dispatcher to Dispatcher. The wrapped
+// dispatcher cannot observe ctx, so a request is only skipped if ctx is
+// already done when it arrives; once started it runs to completion.
+func Legacy(d LegacyDispatcher) Dispatcher {
+	return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+		if err := ctx.Err(); err != nil {
+			return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: CodeTransportError, Message: "request not dispatched", Data: err.Error()}}
+		}
+		return d.Handle(r)
+	})
+}
+
 // EchoDispatcher simple implementation returning static structure.
 // Intended placeholder for routing to device / WRP layer.
 type EchoDispatcher struct{}
 
-func (e EchoDispatcher) Handle(r *Request) *Response {
+// Handle implements Dispatcher.
+func (e EchoDispatcher) Handle(ctx context.Context, r *Request) *Response {
 	if r.Method == "" {
 		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32600, Message: "invalid request"}}
 	}
 	// Simulate a tiny processing delay
-	time.Sleep(5 * time.Millisecond)
+	select {
+	case <-time.After(5 * time.Millisecond):
+	case <-ctx.Done():
+		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: CodeTransportError, Message: "request aborted", Data: ctx.Err().Error()}}
+	}
 	return &Response{JSONRPC: "2.0", ID: r.ID, Result: map[string]interface{}{"echo": true, "method": r.Method}}
 }
 
@@ -68,3 +116,24 @@ func ParseRequest(raw []byte) (*Request, error) {
 	}
 	return &r, nil
 }
+
+// ParseResponse decodes raw as a JSON-RPC response: an object carrying an id
+// and a result or error but no method. ok is false for anything else. The
+// result is kept as raw JSON so it can be relayed unchanged.
+func ParseResponse(raw []byte) (resp *Response, ok bool) {
+	var m struct {
+		JSONRPC string          `json:"jsonrpc"`
+		ID      json.RawMessage `json:"id"`
+		Method  string          `json:"method"`
+		Result  json.RawMessage `json:"result"`
+		Error   *Error          `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil || m.Method != "" || len(m.ID) == 0 || (m.Result == nil && m.Error == nil) {
+		return nil, false
+	}
+	resp = &Response{JSONRPC: m.JSONRPC, ID: m.ID, Error: m.Error}
+	if m.Result != nil {
+		resp.Result = m.Result
+	}
+	return resp, true
+}
@@ -0,0 +1,37 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+type legacyEcho struct{ calls int }
+
+func (l *legacyEcho) Handle(r *Request) *Response {
+	l.calls++
+	return &Response{JSONRPC: "2.0", ID: r.ID, Result: r.Method}
+}
+
+func TestLegacyAdapter(t *testing.T) {
+	l := &legacyEcho{}
+	d := Legacy(l)
+	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"}
+	if resp := d.Handle(context.Background(), req); resp.Error != nil || resp.Result != "Device.Ping" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if resp := d.Handle(ctx, req); resp.Error == nil || l.calls != 1 {
+		t.Fatalf("expected a done context to skip dispatch, got %+v (calls=%d)", resp, l.calls)
+	}
+}
+
+func TestEchoDispatcherHonoursContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"}
+	if resp := (EchoDispatcher{}).Handle(ctx, req); resp.Error == nil {
+		t.Fatalf("expected cancelled request to fail, got %+v", resp)
+	}
+}
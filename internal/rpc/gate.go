@@ -0,0 +1,55 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// ErrDeviceOffline is the cancellation cause of calls to a device that went
+// offline while they were in flight.
+var ErrDeviceOffline = errors.New("device offline")
+
+// DeviceGate lets dispatchers fail fast for devices known to be offline.
+// presence.Table is the gateway's implementation.
+type DeviceGate interface {
+	// Begin admits a call to device. ctx is derived from parent and is
+	// cancelled with cause ErrDeviceOffline if the device goes offline; done
+	// must be called when the call ends. ok is false when the device is
+	// offline and the call should not be attempted.
+	Begin(parent context.Context, device string) (ctx context.Context, done func(), ok bool)
+	// Offline records that upstream reported the device not connected.
+	Offline(device string)
+	// Seen records a completed round trip with the device.
+	Seen(device string)
+}
+
+// beginDevice is DeviceGate.Begin tolerating a nil gate.
+func beginDevice(g DeviceGate, parent context.Context, device string) (context.Context, func(), bool) {
+	if g == nil {
+		return parent, func() {}, true
+	}
+	return g.Begin(parent, device)
+}
+
+// deviceOffline reports whether a failed call ended because device is
+// offline, recording upstream "device not connected" answers with g.
+func deviceOffline(g DeviceGate, ctx context.Context, device string, err error) bool {
+	if errors.Is(err, ErrDeviceNotConnected) {
+		if g != nil {
+			g.Offline(device)
+		}
+		return true
+	}
+	return errors.Is(context.Cause(ctx), ErrDeviceOffline)
+}
+
+func deviceOfflineError(r *Request, device string) *Response {
+	return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: CodeDeviceOffline, Message: "device offline", Data: device}}
+}
+
+// destDevice returns the device part of a WRP destination ("mac:x/service").
+func destDevice(dest string) string {
+	device, _, _ := strings.Cut(dest, "/")
+	return device
+}
@@ -0,0 +1,161 @@
+package rpc
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Adaptive timeout defaults, used for zero LatencyTracker fields.
+const (
+	defaultLatencyWindow     = 256
+	defaultLatencyMinSamples = 20
+	defaultLatencyMultiplier = 3.0
+	defaultLatencyFloor      = time.Second
+	defaultLatencyCeiling    = 60 * time.Second
+	defaultLatencyMaxSeries  = 4096
+	latencyIdleExpiry        = time.Hour
+)
+
+// LatencyTracker keeps rolling round-trip latencies of upstream calls per
+// device and method, and derives timeouts from their p99:
+// p99 × Multiplier clamped to [Floor, Ceiling]. Until MinSamples calls have
+// been recorded no timeout is derived. Methods are chosen by clients, so at
+// most MaxSeries device/method series are kept: the least recently observed
+// is dropped for a new one, as is any idle for an hour. Set the fields before
+// use.
+type LatencyTracker struct {
+	Window     int     // samples kept per device and method (default 256)
+	MinSamples int     // samples needed before deriving a timeout (default 20)
+	Multiplier float64 // applied to the p99 (default 3)
+	Floor      time.Duration
+	Ceiling    time.Duration
+	MaxSeries  int // device/method series kept (default 4096)
+
+	mu     sync.Mutex
+	series map[latencyKey]*list.Element // of *latencySeries
+	lru    list.List                    // most recently observed first
+}
+
+type latencyKey struct{ device, method string }
+
+// latencySeries is a ring of the most recent samples with a cached p99.
+type latencySeries struct {
+	key     latencyKey
+	samples []time.Duration
+	next    int
+	p99     time.Duration
+	dirty   bool
+	last    time.Time
+}
+
+// Observe records the round-trip time of a call.
+func (t *LatencyTracker) Observe(device, method string, d time.Duration) {
+	if t == nil || device == "" {
+		return
+	}
+	now := time.Now()
+	k := latencyKey{strings.ToLower(device), method}
+	window := t.Window
+	if window <= 0 {
+		window = defaultLatencyWindow
+	}
+	maxSeries := t.MaxSeries
+	if maxSeries <= 0 {
+		maxSeries = defaultLatencyMaxSeries
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.series == nil {
+		t.series = make(map[latencyKey]*list.Element)
+	}
+	var s *latencySeries
+	if e, ok := t.series[k]; ok {
+		s = e.Value.(*latencySeries)
+		t.lru.MoveToFront(e)
+	} else {
+		s = &latencySeries{key: k}
+		t.series[k] = t.lru.PushFront(s)
+	}
+	for e := t.lru.Back(); e != nil && e.Value != s; e = t.lru.Back() {
+		old := e.Value.(*latencySeries)
+		if t.lru.Len() <= maxSeries && now.Sub(old.last) <= latencyIdleExpiry {
+			break
+		}
+		t.lru.Remove(e)
+		delete(t.series, old.key)
+	}
+	if len(s.samples) < window {
+		s.samples = append(s.samples, d)
+	} else {
+		s.samples[s.next%window] = d
+	}
+	s.next++
+	s.dirty, s.last = true, now
+}
+
+// observeAttempt records an attempt begun at start: its round trip when it
+// completed, or the time it was given when ctx's deadline cut it short. That
+// is only a lower bound, but leaving timed-out calls out would hide exactly
+// the slow tail, and a device whose latency grew past its derived timeout
+// would never be given a longer one.
+func (t *LatencyTracker) observeAttempt(ctx context.Context, device, method string, start time.Time, err error) {
+	if err == nil || errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		t.Observe(device, method, time.Since(start))
+	}
+}
+
+// P99 returns the 99th-percentile latency observed for device and method,
+// and how many samples it is based on.
+func (t *LatencyTracker) P99(device, method string) (time.Duration, int) {
+	if t == nil {
+		return 0, 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.series[latencyKey{strings.ToLower(device), method}]
+	if !ok {
+		return 0, 0
+	}
+	s := e.Value.(*latencySeries)
+	if s.dirty {
+		sorted := slices.Clone(s.samples)
+		slices.Sort(sorted)
+		s.p99 = sorted[int(math.Ceil(0.99*float64(len(sorted))))-1]
+		s.dirty = false
+	}
+	return s.p99, len(s.samples)
+}
+
+// Timeout derives a timeout for device and method from the observed p99.
+// ok is false until enough samples have been recorded.
+func (t *LatencyTracker) Timeout(device, method string) (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	p99, n := t.P99(device, method)
+	minSamples := t.MinSamples
+	if minSamples <= 0 {
+		minSamples = defaultLatencyMinSamples
+	}
+	if n < minSamples {
+		return 0, false
+	}
+	mult, floor, ceiling := t.Multiplier, t.Floor, t.Ceiling
+	if mult <= 0 {
+		mult = defaultLatencyMultiplier
+	}
+	if floor <= 0 {
+		floor = defaultLatencyFloor
+	}
+	if ceiling <= 0 {
+		ceiling = defaultLatencyCeiling
+	}
+	d := time.Duration(float64(p99) * mult)
+	return min(max(d, floor), ceiling), true
+}
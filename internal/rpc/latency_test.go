@@ -0,0 +1,119 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+func TestLatencyTrackerTimeout(t *testing.T) {
+	lt := &LatencyTracker{Window: 100, MinSamples: 10, Multiplier: 2, Floor: 500 * time.Millisecond, Ceiling: 10 * time.Second}
+	for i := 0; i < 9; i++ {
+		lt.Observe("mac:112233445566", "Device.Info", 100*time.Millisecond)
+	}
+	if _, ok := lt.Timeout("mac:112233445566", "Device.Info"); ok {
+		t.Fatal("expected no timeout before MinSamples")
+	}
+	for i := 0; i < 90; i++ {
+		lt.Observe("MAC:112233445566", "Device.Info", 100*time.Millisecond)
+	}
+	lt.Observe("mac:112233445566", "Device.Info", 2*time.Second) // 1 of 100: above p99
+	if p99, n := lt.P99("mac:112233445566", "Device.Info"); p99 != 100*time.Millisecond || n != 100 {
+		t.Fatalf("P99 = %s over %d samples", p99, n)
+	}
+	// 2 × 100ms is below the floor.
+	if d, ok := lt.Timeout("mac:112233445566", "Device.Info"); !ok || d != 500*time.Millisecond {
+		t.Fatalf("Timeout = %s, %v; want floor", d, ok)
+	}
+	// The window rolls: slow samples push out the fast ones.
+	for i := 0; i < 100; i++ {
+		lt.Observe("mac:112233445566", "Device.Info", 3*time.Second)
+	}
+	if d, _ := lt.Timeout("mac:112233445566", "Device.Info"); d != 6*time.Second {
+		t.Fatalf("Timeout = %s; want 6s", d)
+	}
+	for i := 0; i < 100; i++ {
+		lt.Observe("mac:112233445566", "Device.Info", 30*time.Second)
+	}
+	if d, _ := lt.Timeout("mac:112233445566", "Device.Info"); d != 10*time.Second {
+		t.Fatalf("Timeout = %s; want ceiling", d)
+	}
+	if _, ok := lt.Timeout("mac:112233445566", "Device.Reboot"); ok {
+		t.Fatal("methods must be tracked separately")
+	}
+	if _, ok := (*LatencyTracker)(nil).Timeout("mac:112233445566", "Device.Info"); ok {
+		t.Fatal("nil tracker derived a timeout")
+	}
+}
+
+func TestLatencyTrackerBoundsSeries(t *testing.T) {
+	lt := &LatencyTracker{MinSamples: 1, MaxSeries: 3}
+	lt.Observe("mac:112233445566", "Device.Info", time.Second)
+	for i := 0; i < 10; i++ {
+		lt.Observe("mac:112233445566", fmt.Sprintf("Junk.%d", i), time.Second)
+		lt.Observe("mac:112233445566", "Device.Info", time.Second) // kept recent
+	}
+	if len(lt.series) != 3 || lt.lru.Len() != 3 {
+		t.Fatalf("expected 3 series, got %d", len(lt.series))
+	}
+	if _, n := lt.P99("mac:112233445566", "Device.Info"); n != 11 {
+		t.Fatalf("recently observed series evicted: %d samples", n)
+	}
+	if _, n := lt.P99("mac:112233445566", "Junk.0"); n != 0 {
+		t.Fatal("least recently observed series kept")
+	}
+}
+
+func TestMultiServiceDispatcherObservesLatency(t *testing.T) {
+	lt := &LatencyTracker{}
+	d := &MultiServiceDispatcher{Client: &fakeWRPClient{}, Source: "src", DeviceID: "11:22:33:44:55:66", DestPrefix: "mac:", Services: []string{"BlizzardRDK", "config"}, Latency: lt}
+	d.Handle(context.Background(), &Request{JSONRPC: "2.0", ID: json.RawMessage(`"abc"`), Method: "Device.Ping"})
+	// The failed attempt did not time out, so only the completed one is
+	// recorded, under the canonical device.
+	if _, n := lt.P99("mac:112233445566", "Device.Ping"); n != 1 {
+		t.Fatalf("expected 1 sample, got %d", n)
+	}
+}
+
+// slowWRPClient answers after delay unless the call's context ends first.
+type slowWRPClient struct{ delay time.Duration }
+
+func (s slowWRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, error) {
+	select {
+	case <-time.After(s.delay):
+		return &wrp.Message{Payload: []byte(`{"jsonrpc":"2.0","result":true}`)}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestLatencyTrackerLearnsFromTimeouts(t *testing.T) {
+	lt := &LatencyTracker{MinSamples: 5, Multiplier: 2, Floor: 50 * time.Millisecond, Ceiling: 5 * time.Second}
+	for i := 0; i < 5; i++ {
+		lt.Observe("mac:112233445566", "Device.Info", 20*time.Millisecond)
+	}
+	// The device slows to 300ms, well past the 50ms derived so far. Each
+	// timed-out call counts as its whole budget, so the timeout keeps
+	// doubling until calls fit.
+	d := &MultiServiceDispatcher{Client: slowWRPClient{delay: 300 * time.Millisecond}, DeviceID: "112233445566", DestPrefix: "mac:", Services: []string{"BlizzardRDK"}, Latency: lt}
+	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Info"}
+	for round := 1; ; round++ {
+		timeout, ok := lt.Timeout("mac:112233445566", "Device.Info")
+		if !ok {
+			t.Fatal("expected a derived timeout")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		resp := d.Handle(ctx, req)
+		cancel()
+		if resp.Error == nil {
+			break
+		}
+		if round == 5 {
+			t.Fatalf("timeout stuck at %s after %d timed-out calls", timeout, round)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware wraps a Dispatcher with a cross-cutting concern (logging,
+// metrics, validation, policy checks, ...). It may answer a request itself
+// instead of calling next.
+type Middleware func(next Dispatcher) Dispatcher
+
+// Chain wraps d with mw, the first middleware being the outermost.
+func Chain(d Dispatcher, mw ...Middleware) Dispatcher {
+	for i := len(mw) - 1; i >= 0; i-- {
+		d = mw[i](d)
+	}
+	return d
+}
+
+var (
+	middlewareMu sync.RWMutex
+	middlewares  = map[string]Middleware{
+		"recover":  Recover,
+		"log":      Logging,
+		"validate": Validate,
+		"metrics":  Metrics,
+	}
+)
+
+// RegisterMiddleware makes mw available by name to MiddlewareByName, so
+// deployments can add their own policy hooks (typically from an init func)
+// and order them in configuration. Registering an existing name replaces it.
+func RegisterMiddleware(name string, mw Middleware) {
+	middlewareMu.Lock()
+	defer middlewareMu.Unlock()
+	middlewares[name] = mw
+}
+
+// MiddlewareByName resolves configured middleware names, in order. Built-in
+// names are "recover", "log", "validate" and "metrics".
+func MiddlewareByName(names []string) ([]Middleware, error) {
+	middlewareMu.RLock()
+	defer middlewareMu.RUnlock()
+	out := make([]Middleware, 0, len(names))
+	for _, n := range names {
+		mw, ok := middlewares[strings.TrimSpace(n)]
+		if !ok {
+			known := make([]string, 0, len(middlewares))
+			for k := range middlewares {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown middleware %q (known: %s)", n, strings.Join(known, ", "))
+		}
+		out = append(out, mw)
+	}
+	return out, nil
+}
+
+// Recover turns a panic in the wrapped dispatcher into an internal error
+// response instead of taking the gateway down.
+func Recover(next Dispatcher) Dispatcher {
+	return DispatcherFunc(func(ctx context.Context, r *Request) (resp *Response) {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("dispatcher panic method=%s panic=%v\n%s", r.Method, p, debug.Stack())
+				resp = &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32603, Message: "internal error"}}
+			}
+		}()
+		return next.Handle(ctx, r)
+	})
+}
+
+// Logging logs each request's method, peer, duration and error code.
+func Logging(next Dispatcher) Dispatcher {
+	return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+		start := time.Now()
+		resp := next.Handle(ctx, r)
+		code := 0
+		if resp != nil && resp.Error != nil {
+			code = resp.Error.Code
+		}
+		p, _ := PeerFrom(ctx)
+		log.Printf("rpc method=%s id=%s conn=%s device=%s duration=%s error_code=%d", r.Method, r.ID, p.ConnID, p.Device, time.Since(start).Round(time.Microsecond), code)
+		return resp
+	})
+}
+
+// Validate rejects requests whose params are neither an object nor an array
+// (JSON-RPC 2.0 §4.2) before they reach the device.
+func Validate(next Dispatcher) Dispatcher {
+	return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+		if p := strings.TrimSpace(string(r.Params)); p != "" && p[0] != '{' && p[0] != '[' {
+			return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32602, Message: "invalid params", Data: "params must be an object or array"}}
+		}
+		if len(r.Params) > 0 && !json.Valid(r.Params) {
+			return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32602, Message: "invalid params", Data: "params are not valid JSON"}}
+		}
+		return next.Handle(ctx, r)
+	})
+}
+
+// rpcMetrics is published at /debug/vars (expvar) as "rpc": total requests,
+// cumulative latency and error counts per code. Methods are client-chosen,
+// so they are deliberately not used as keys.
+var rpcMetrics = expvar.NewMap("rpc")
+
+// Metrics counts requests, errors and latency into the "rpc" expvar map.
+func Metrics(next Dispatcher) Dispatcher {
+	return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+		start := time.Now()
+		resp := next.Handle(ctx, r)
+		rpcMetrics.Add("requests", 1)
+		rpcMetrics.Add("latency_us", time.Since(start).Microseconds())
+		if resp != nil && resp.Error != nil {
+			rpcMetrics.Add("errors."+strconv.Itoa(resp.Error.Code), 1)
+		}
+		return resp
+	})
+}
+
+// Authorizer decides whether the peer may make request r. A non-nil error
+// refuses it.
+type Authorizer func(ctx context.Context, p Peer, r *Request) error
+
+// Authorize returns middleware applying check to every request, answering
+// refused ones with CodeUnauthorized and the error text as data. It is the
+// hook for per-request authorization; register it to use it from
+// configuration, e.g. RegisterMiddleware("auth", Authorize(check)).
+func Authorize(check Authorizer) Middleware {
+	return func(next Dispatcher) Dispatcher {
+		return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+			p, _ := PeerFrom(ctx)
+			if err := check(ctx, p, r); err != nil {
+				return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: CodeUnauthorized, Message: "unauthorized", Data: err.Error()}}
+			}
+			return next.Handle(ctx, r)
+		})
+	}
+}
+
+// Peer describes the connection a request arrived on, for middleware that
+// needs to know who is calling.
+type Peer struct {
+	ConnID     string
+	RemoteAddr string
+	Principal  string // see ws principalOf
+	Device     string // bound device ("" on a multiplexed connection)
+	Service    string
+	// Authorization is the upgrade request's Authorization header, for
+	// Authorize hooks. Never log it.
+	Authorization string
+}
+
+type peerKey struct{}
+
+// WithPeer returns ctx carrying p.
+func WithPeer(ctx context.Context, p Peer) context.Context {
+	return context.WithValue(ctx, peerKey{}, p)
+}
+
+// PeerFrom returns the Peer stored by WithPeer.
+func PeerFrom(ctx context.Context) (Peer, bool) {
+	p, ok := ctx.Value(peerKey{}).(Peer)
+	return p, ok
+}
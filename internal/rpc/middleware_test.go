@@ -0,0 +1,74 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestChainOrder(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next Dispatcher) Dispatcher {
+			return DispatcherFunc(func(ctx context.Context, r *Request) *Response {
+				order = append(order, name)
+				return next.Handle(ctx, r)
+			})
+		}
+	}
+	RegisterMiddleware("test-b", tag("b"))
+	t.Cleanup(func() {
+		middlewareMu.Lock()
+		defer middlewareMu.Unlock()
+		delete(middlewares, "test-b")
+	})
+	mw, err := MiddlewareByName([]string{"test-b", "recover"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Chain(EchoDispatcher{}, append([]Middleware{tag("a")}, mw...)...)
+	d.Handle(context.Background(), &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"})
+	if len(order) != 2 || order[0] != "a" || order[1] != "b" {
+		t.Fatalf("expected outermost-first order [a b], got %v", order)
+	}
+	if _, err := MiddlewareByName([]string{"nope"}); err == nil {
+		t.Fatal("expected error for unknown middleware")
+	}
+}
+
+func TestRecoverAndValidate(t *testing.T) {
+	panicky := DispatcherFunc(func(context.Context, *Request) *Response { panic("boom") })
+	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"}
+	if resp := Recover(panicky).Handle(context.Background(), req); resp == nil || resp.Error == nil || resp.Error.Code != -32603 {
+		t.Fatalf("expected internal error from recovered panic, got %+v", resp)
+	}
+
+	d := Validate(EchoDispatcher{})
+	for _, params := range []string{`"scalar"`, `42`} {
+		r := &Request{JSONRPC: "2.0", ID: json.RawMessage(`2`), Method: "Device.Ping", Params: json.RawMessage(params)}
+		if resp := d.Handle(context.Background(), r); resp.Error == nil || resp.Error.Code != -32602 {
+			t.Fatalf("params %s: expected invalid params, got %+v", params, resp)
+		}
+	}
+	r := &Request{JSONRPC: "2.0", ID: json.RawMessage(`3`), Method: "Device.Ping", Params: json.RawMessage(`{"a":1}`)}
+	if resp := d.Handle(context.Background(), r); resp.Error != nil {
+		t.Fatalf("expected object params to pass, got %+v", resp)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Reboot"}
+	auth := Authorize(func(_ context.Context, p Peer, r *Request) error {
+		if r.Method == "Device.Reboot" && p.Authorization != "Bearer admin" {
+			return errors.New("reboot requires admin")
+		}
+		return nil
+	})(EchoDispatcher{})
+	if resp := auth.Handle(WithPeer(context.Background(), Peer{Authorization: "Bearer user"}), req); resp.Error == nil || resp.Error.Code != CodeUnauthorized || resp.Error.Data != "reboot requires admin" {
+		t.Fatalf("expected unauthorized, got %+v", resp)
+	}
+	if resp := auth.Handle(WithPeer(context.Background(), Peer{Authorization: "Bearer admin"}), req); resp.Error != nil {
+		t.Fatalf("expected authorized call to pass, got %+v", resp)
+	}
+}
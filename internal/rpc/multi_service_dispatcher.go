@@ -7,6 +7,8 @@ import (
 	"time"
 
 	wrp "github.com/xmidt-org/wrp-go/v3"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
 )
 
 // MultiServiceDispatcher attempts a JSON-RPC request across multiple service
@@ -31,27 +33,51 @@ type MultiServiceDispatcher struct {
 	DeviceID   string
 	DestPrefix string // e.g. "mac:" (may be empty)
 	Services   []string
-	Timeout    time.Duration // per-attempt timeout (default 8s)
+	Timeout    time.Duration   // per-attempt timeout when ctx has no deadline (default 8s)
+	Gate       DeviceGate      // optional; fails calls fast while the device is offline
+	Latency    *LatencyTracker // optional; records round trips, timed-out ones as their budget
+	Retry      *RetryPolicy    // optional; retries each service's transient failures
 }
 
-func (m *MultiServiceDispatcher) Handle(r *Request) *Response {
+// Handle implements Dispatcher. Once ctx is done no further service
+// candidates are attempted. A deadline on ctx bounds all attempts together
+// and replaces the per-attempt Timeout: each service gets an equal share of
+// the time left when it is tried (see serviceContext).
+func (m *MultiServiceDispatcher) Handle(parent context.Context, r *Request) *Response {
 	if len(m.Services) == 0 {
 		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32603, Message: "no services configured"}}
 	}
 	if m.Client == nil {
 		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32603, Message: "no client configured"}}
 	}
-	if m.Timeout <= 0 {
-		m.Timeout = 8 * time.Second
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
 	}
+	_, hasDeadline := parent.Deadline()
 	rawReq, err := json.Marshal(r)
 	if err != nil {
 		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32603, Message: "marshal request failed", Data: err.Error()}}
 	}
+	// Canonicalize the device (separators, case, duplicate scheme); ids that
+	// do not parse are used verbatim.
+	device := m.DestPrefix + m.DeviceID
+	if id, err := deviceid.Parse(m.DeviceID, deviceid.SchemeOf(m.DestPrefix)); err == nil {
+		device = id.String()
+	}
+	parent, done, ok := beginDevice(m.Gate, parent, device)
+	if !ok {
+		return deviceOfflineError(r, device)
+	}
+	defer done()
 	var lastErr error
 	var attempts []map[string]string
-	for _, svc := range m.Services {
-		dest := fmt.Sprintf("%s%s/%s", m.DestPrefix, m.DeviceID, svc)
+	retry := m.Retry.retrier(r.Method)
+	for i, svc := range m.Services {
+		if parent.Err() != nil {
+			break
+		}
+		dest := device + "/" + svc
 		msg := &wrp.Message{
 			Type:            wrp.SimpleRequestResponseMessageType,
 			Source:          m.Source,
@@ -61,14 +87,31 @@ func (m *MultiServiceDispatcher) Handle(r *Request) *Response {
 			ContentType:     "application/json",
 			Payload:         rawReq,
 		}
-		ctx, cancel := context.WithTimeout(context.Background(), m.Timeout)
-		upstream, sendErr := m.Client.Do(ctx, msg)
+		svcCtx, cancel := serviceContext(parent, len(m.Services)-i)
+		upstream, sendErr := retry.send(svcCtx, svc, func(ctx context.Context) (*wrp.Message, error) {
+			if !hasDeadline {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			upstream, err := m.Client.Do(ctx, msg)
+			m.Latency.observeAttempt(ctx, device, r.Method, start, err)
+			return upstream, err
+		})
 		cancel()
+		if sendErr != nil && deviceOffline(m.Gate, parent, device, sendErr) {
+			// No other service can reach a disconnected device.
+			return deviceOfflineError(r, device)
+		}
 		if sendErr != nil {
 			lastErr = fmt.Errorf("svc=%s dest=%s err=%w", svc, dest, sendErr)
 			attempts = append(attempts, map[string]string{"service": svc, "status": "transport_error"})
 			continue
 		}
+		if m.Gate != nil {
+			m.Gate.Seen(device)
+		}
 		// Attempt to decode a JSON-RPC response.
 		var jr Response
 		if err := json.Unmarshal(upstream.Payload, &jr); err == nil && jr.JSONRPC == "2.0" {
@@ -80,5 +123,21 @@ func (m *MultiServiceDispatcher) Handle(r *Request) *Response {
 		// If payload isn't JSON-RPC, wrap as a success result blob.
 		return &Response{JSONRPC: "2.0", ID: r.ID, Result: json.RawMessage(upstream.Payload)}
 	}
+	if retry.enabled() {
+		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32100, Message: "transport error", Data: map[string]any{"device": device, "attempts": retry.history}}}
+	}
 	return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32100, Message: "transport error", Data: fmt.Sprintf("attempts=%v last=%v", attempts, lastErr)}}
 }
+
+// serviceContext bounds the attempts at the next of n remaining services to
+// an equal share of the time left before parent's deadline, so a slow service
+// cannot starve the fallbacks after it; the last service gets all that is
+// left. Without a deadline parent is returned unchanged and each attempt
+// applies the per-attempt Timeout instead.
+func serviceContext(parent context.Context, n int) (context.Context, context.CancelFunc) {
+	deadline, ok := parent.Deadline()
+	if !ok || n <= 1 {
+		return parent, func() {}
+	}
+	return context.WithTimeout(parent, time.Until(deadline)/time.Duration(n))
+}
@@ -30,7 +30,7 @@ func TestMultiServiceDispatcherFallback(t *testing.T) {
 	f := &fakeWRPClient{}
 	d := &MultiServiceDispatcher{Client: f, Source: "src", DeviceID: "dev1", DestPrefix: "mac:", Services: []string{"BlizzardRDK", "config"}, Timeout: 100 * time.Millisecond}
 	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`"abc"`), Method: "Device.Ping"}
-	resp := d.Handle(req)
+	resp := d.Handle(context.Background(), req)
 	if resp == nil || resp.Error != nil {
 		t.Fatalf("expected success, got %+v", resp)
 	}
@@ -44,3 +44,29 @@ func TestMultiServiceDispatcherFallback(t *testing.T) {
 }
 
 // (proxy type removed; fake implements interface directly)
+
+// hangingWRPClient never answers for service hang until the call's context
+// ends; other services reply at once.
+type hangingWRPClient struct{ hang string }
+
+func (h hangingWRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, error) {
+	if m.ServiceName == h.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return &wrp.Message{Payload: []byte(`{"jsonrpc":"2.0","result":"` + m.ServiceName + `"}`)}, nil
+}
+
+func TestMultiServiceDispatcherSplitsDeadline(t *testing.T) {
+	d := &MultiServiceDispatcher{Client: hangingWRPClient{hang: "BlizzardRDK"}, DeviceID: "112233445566", DestPrefix: "mac:", Services: []string{"BlizzardRDK", "config"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 400*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp := d.Handle(ctx, &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping"})
+	if resp.Error != nil || resp.Result != "config" {
+		t.Fatalf("expected the fallback to answer within the deadline, got %+v", resp)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 300*time.Millisecond {
+		t.Fatalf("first service held %s of the 400ms budget, want about half", elapsed)
+	}
+}
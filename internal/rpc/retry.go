@@ -0,0 +1,143 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"path"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+// Retry defaults, used for zero RetryPolicy fields.
+const (
+	defaultRetryAttempts  = 3
+	defaultRetryBaseDelay = 100 * time.Millisecond
+	defaultRetryMaxDelay  = 2 * time.Second
+)
+
+// RetryPolicy repeats upstream calls of methods that are safe to repeat when
+// they fail transiently (see Retryable). Backoff doubles from BaseDelay up to
+// MaxDelay, with jitter. Retrying stops after MaxAttempts, when the next
+// attempt would start more than Deadline after the first, or when the
+// caller's context ends. Methods not listed are attempted once.
+type RetryPolicy struct {
+	Methods     []string      // path.Match globs of idempotent methods
+	MaxAttempts int           // attempts per service including the first (default 3)
+	BaseDelay   time.Duration // delay before the first retry (default 100ms)
+	MaxDelay    time.Duration // backoff cap (default 2s)
+	Deadline    time.Duration // optional bound on the span of all attempts
+}
+
+// Allows reports whether method may be retried.
+func (p *RetryPolicy) Allows(method string) bool {
+	if p == nil {
+		return false
+	}
+	for _, pat := range p.Methods {
+		if ok, _ := path.Match(pat, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Retryable reports whether a WRPDoer.Do error is transient: a transport
+// failure or a 5xx from Scytale. A device that is not connected (404) is not.
+func Retryable(err error) bool {
+	if err == nil || errors.Is(err, ErrDeviceNotConnected) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.Code >= 500
+	}
+	return true
+}
+
+// backoff returns the jittered delay before retry n (1-based): uniformly
+// between half and all of BaseDelay·2^(n-1), capped at MaxDelay.
+func (p *RetryPolicy) backoff(n int) time.Duration {
+	base, maxDelay := p.BaseDelay, p.MaxDelay
+	if base <= 0 {
+		base = defaultRetryBaseDelay
+	}
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	d := base
+	for i := 1; i < n && d < maxDelay; i++ {
+		d *= 2
+	}
+	d = min(d, maxDelay)
+	return d/2 + rand.N(d/2+1)
+}
+
+// Attempt is one failed upstream attempt, reported in Error.Data when a
+// retryable method's call fails.
+type Attempt struct {
+	Attempt int    `json:"attempt"`
+	Service string `json:"service,omitempty"`
+	Status  int    `json:"status,omitempty"` // HTTP status from Scytale, 0 for transport failures
+	Error   string `json:"error"`
+	DelayMs int64  `json:"delayMs,omitempty"` // backoff waited before this attempt
+}
+
+// retrier carries one call's attempts across the services it tries.
+type retrier struct {
+	policy  *RetryPolicy
+	max     int
+	start   time.Time
+	history []Attempt
+}
+
+// retrier returns the retry state for one call of method.
+func (p *RetryPolicy) retrier(method string) *retrier {
+	r := &retrier{policy: p, max: 1, start: time.Now()}
+	if p.Allows(method) {
+		r.max = p.MaxAttempts
+		if r.max <= 0 {
+			r.max = defaultRetryAttempts
+		}
+	}
+	return r
+}
+
+// enabled reports whether the call may be retried, and so reports history.
+func (r *retrier) enabled() bool { return r.max > 1 }
+
+// send runs do for service until it succeeds, fails permanently or the
+// policy gives up, recording failed attempts.
+func (r *retrier) send(ctx context.Context, service string, do func(context.Context) (*wrp.Message, error)) (*wrp.Message, error) {
+	var delay time.Duration
+	for n := 1; ; n++ {
+		msg, err := do(ctx)
+		if err == nil {
+			return msg, nil
+		}
+		a := Attempt{Attempt: len(r.history) + 1, Service: service, Error: err.Error(), DelayMs: delay.Milliseconds()}
+		var se *StatusError
+		if errors.As(err, &se) {
+			a.Status = se.Code
+		}
+		r.history = append(r.history, a)
+		if n >= r.max || !Retryable(err) || ctx.Err() != nil {
+			return nil, err
+		}
+		delay = r.policy.backoff(n)
+		if r.policy.Deadline > 0 && time.Since(r.start)+delay > r.policy.Deadline {
+			return nil, err
+		}
+		if dl, ok := ctx.Deadline(); ok && time.Until(dl) <= delay {
+			return nil, err
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+	}
+}
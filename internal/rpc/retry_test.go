@@ -0,0 +1,146 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+// flakyScytale answers the first failures requests with status, then
+// succeeds with an empty WRP message.
+func flakyScytale(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) <= failures {
+			http.Error(w, "upstream hiccup", status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/msgpack")
+		_ = wrp.NewEncoder(w, wrp.Msgpack).Encode(&wrp.Message{Payload: []byte(`{"jsonrpc":"2.0","result":"ok"}`)})
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func TestWRPDispatcherRetry(t *testing.T) {
+	policy := &RetryPolicy{Methods: []string{"Device.Get*"}, MaxAttempts: 3, BaseDelay: time.Millisecond}
+	req := func(method string) *Request {
+		return &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: method}
+	}
+
+	srv, hits := flakyScytale(t, 2, http.StatusServiceUnavailable)
+	d := &WRPDispatcher{Client: &WRPClient{URL: srv.URL}, Dest: "mac:112233445566/BlizzardRDK", Retry: policy}
+	if resp := d.Handle(context.Background(), req("Device.GetInfo")); resp.Error != nil || resp.Result != "ok" || hits.Load() != 3 {
+		t.Fatalf("expected success on third attempt, got %+v after %d hits", resp, hits.Load())
+	}
+
+	// Methods not marked idempotent are attempted once.
+	srv, hits = flakyScytale(t, 2, http.StatusServiceUnavailable)
+	d.Client = &WRPClient{URL: srv.URL}
+	if resp := d.Handle(context.Background(), req("Device.Reboot")); resp.Error == nil || hits.Load() != 1 {
+		t.Fatalf("expected a single failed attempt, got %+v after %d hits", resp, hits.Load())
+	}
+
+	// Exhausted retries report every attempt.
+	srv, hits = flakyScytale(t, 5, http.StatusGatewayTimeout)
+	d.Client = &WRPClient{URL: srv.URL}
+	resp := d.Handle(context.Background(), req("Device.GetInfo"))
+	if resp.Error == nil || resp.Error.Code != -32100 || hits.Load() != 3 {
+		t.Fatalf("expected transport error after 3 attempts, got %+v after %d hits", resp, hits.Load())
+	}
+	data, _ := resp.Error.Data.(map[string]any)
+	attempts, _ := data["attempts"].([]Attempt)
+	if len(attempts) != 3 || attempts[2].Attempt != 3 || attempts[2].Status != http.StatusGatewayTimeout || attempts[0].DelayMs != 0 {
+		t.Fatalf("unexpected attempt history: %+v", resp.Error.Data)
+	}
+
+	// Client errors are not transient.
+	srv, hits = flakyScytale(t, 5, http.StatusBadRequest)
+	d.Client = &WRPClient{URL: srv.URL}
+	if resp := d.Handle(context.Background(), req("Device.GetInfo")); resp.Error == nil || hits.Load() != 1 {
+		t.Fatalf("expected 400 not to be retried, got %+v after %d hits", resp, hits.Load())
+	}
+}
+
+func TestRetryStopsAtDeadline(t *testing.T) {
+	srv, hits := flakyScytale(t, 100, http.StatusBadGateway)
+	d := &WRPDispatcher{Client: &WRPClient{URL: srv.URL}, Dest: "mac:112233445566/BlizzardRDK",
+		Retry: &RetryPolicy{Methods: []string{"*"}, MaxAttempts: 100, BaseDelay: 20 * time.Millisecond, MaxDelay: 20 * time.Millisecond, Deadline: 50 * time.Millisecond}}
+	start := time.Now()
+	d.Handle(context.Background(), &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.GetInfo"})
+	if n := hits.Load(); n < 2 || n > 6 {
+		t.Fatalf("expected a few attempts within the deadline, got %d", n)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retries ran %s past the deadline", elapsed)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{errors.New("connection refused"), true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+		{&StatusError{Code: 500}, true},
+		{&StatusError{Code: 504}, true},
+		{fmt.Errorf("svc: %w", &StatusError{Code: 503}), true},
+		{&StatusError{Code: 429}, false},
+		{&StatusError{Code: 404}, false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.err); got != tt.want {
+			t.Errorf("Retryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p := &RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, ceiling := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 6: time.Second} {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(n); d < ceiling/2 || d > ceiling {
+				t.Fatalf("backoff(%d) = %s, want within [%s, %s]", n, d, ceiling/2, ceiling)
+			}
+		}
+	}
+}
+
+// scriptedWRPClient fails each service's first calls with errs[service].
+type scriptedWRPClient struct {
+	errs  map[string][]error
+	calls []string
+}
+
+func (s *scriptedWRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, error) {
+	s.calls = append(s.calls, m.ServiceName)
+	if errs := s.errs[m.ServiceName]; len(errs) > 0 {
+		s.errs[m.ServiceName] = errs[1:]
+		return nil, errs[0]
+	}
+	return &wrp.Message{Payload: []byte(`{"jsonrpc":"2.0","result":"` + m.ServiceName + `"}`)}, nil
+}
+
+func TestMultiServiceDispatcherRetry(t *testing.T) {
+	c := &scriptedWRPClient{errs: map[string][]error{
+		"BlizzardRDK": {&StatusError{Code: 503}, &StatusError{Code: 503}},
+	}}
+	d := &MultiServiceDispatcher{Client: c, DeviceID: "112233445566", DestPrefix: "mac:", Services: []string{"BlizzardRDK", "config"},
+		Retry: &RetryPolicy{Methods: []string{"Device.GetInfo"}, MaxAttempts: 2, BaseDelay: time.Millisecond}}
+	resp := d.Handle(context.Background(), &Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.GetInfo"})
+	// Each service gets MaxAttempts before falling back to the next.
+	if resp.Error != nil || resp.Result != "config" || fmt.Sprint(c.calls) != "[BlizzardRDK BlizzardRDK config]" {
+		t.Fatalf("unexpected outcome %+v after calls %v", resp, c.calls)
+	}
+}
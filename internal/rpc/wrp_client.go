@@ -8,7 +8,6 @@ import (
 	"io"
 	"net/http"
 	"strings"
-	"time"
 
 	wrp "github.com/xmidt-org/wrp-go/v3"
 )
@@ -23,12 +22,39 @@ type WRPClient struct {
 var (
 	// ErrBadStatus indicates a non-2xx response from upstream.
 	ErrBadStatus = errors.New("upstream returned non-2xx status")
+	// ErrDeviceNotConnected indicates Scytale answered 404: no Talaria holds
+	// a connection to the destination device. It accompanies ErrBadStatus.
+	ErrDeviceNotConnected = errors.New("device not connected")
 )
 
+// StatusError is returned for a non-2xx answer from upstream. It matches
+// ErrBadStatus, and ErrDeviceNotConnected for a 404, with errors.Is.
+type StatusError struct {
+	Code int
+	Body string // excerpt
+}
+
+func (e *StatusError) Error() string {
+	if e.Code == http.StatusNotFound {
+		return fmt.Sprintf("%v (%d): %v: %s", ErrBadStatus, e.Code, ErrDeviceNotConnected, e.Body)
+	}
+	return fmt.Sprintf("%v (%d): %s", ErrBadStatus, e.Code, e.Body)
+}
+
+func (e *StatusError) Unwrap() []error {
+	if e.Code == http.StatusNotFound {
+		return []error{ErrBadStatus, ErrDeviceNotConnected}
+	}
+	return []error{ErrBadStatus}
+}
+
 // Do sends a WRP message and decodes the WRP response.
 func (wc *WRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, error) {
-	if wc.Client == nil {
-		wc.Client = &http.Client{Timeout: 10 * time.Second}
+	client := wc.Client
+	if client == nil {
+		// No client-wide timeout: each call is bounded by its context, which
+		// the dispatchers always give a deadline.
+		client = http.DefaultClient
 	}
 	buf := &bytes.Buffer{}
 	if err := wrp.NewEncoder(buf, wrp.Msgpack).Encode(m); err != nil {
@@ -40,22 +66,16 @@ func (wc *WRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, erro
 	}
 	req.Header.Set("Content-Type", "application/msgpack")
 	if wc.Authorization != "" {
-		auth := strings.TrimSpace(wc.Authorization)
-		lower := strings.ToLower(auth)
-		// If it already starts with a known auth scheme, pass through.
-		if !(strings.HasPrefix(lower, "basic ") || strings.HasPrefix(lower, "bearer ") || strings.HasPrefix(lower, "digest ")) {
-			auth = "Basic " + auth
-		}
-		req.Header.Set("Authorization", auth)
+		req.Header.Set("Authorization", AuthorizationHeader(wc.Authorization))
 	}
-	resp, err := wc.Client.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode/100 != 2 {
 		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
-		return nil, fmt.Errorf("%w: %s", ErrBadStatus, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 	var out wrp.Message
 	if err := wrp.NewDecoder(resp.Body, wrp.Msgpack).Decode(&out); err != nil {
@@ -63,3 +83,15 @@ func (wc *WRPClient) Do(ctx context.Context, m *wrp.Message) (*wrp.Message, erro
 	}
 	return &out, nil
 }
+
+// AuthorizationHeader returns the Authorization header value for cred: values
+// that already start with a known auth scheme pass through, anything else is
+// taken as Basic credentials.
+func AuthorizationHeader(cred string) string {
+	auth := strings.TrimSpace(cred)
+	lower := strings.ToLower(auth)
+	if !(strings.HasPrefix(lower, "basic ") || strings.HasPrefix(lower, "bearer ") || strings.HasPrefix(lower, "digest ")) {
+		auth = "Basic " + auth
+	}
+	return auth
+}
@@ -2,9 +2,11 @@ package rpc
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 
 	wrp "github.com/xmidt-org/wrp-go/v3"
@@ -48,3 +50,36 @@ func TestWRPClientAuthPrefixing(t *testing.T) {
 		}
 	}
 }
+
+func TestWRPClientDeviceNotConnected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "device not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+	_, err := (&WRPClient{URL: srv.URL}).Do(context.Background(), &wrp.Message{})
+	if !errors.Is(err, ErrDeviceNotConnected) || !errors.Is(err, ErrBadStatus) {
+		t.Fatalf("expected device-not-connected bad status, got %v", err)
+	}
+}
+
+// Do is called from every connection's goroutines on one shared client; run
+// with -race.
+func TestWRPClientConcurrentDo(t *testing.T) {
+	srv := newEchoWRPServer(t, nil)
+	defer srv.Close()
+	client := &WRPClient{URL: srv.URL}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := client.Do(context.Background(), &wrp.Message{}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if client.Client != nil {
+		t.Fatal("Do must not modify the shared client")
+	}
+}
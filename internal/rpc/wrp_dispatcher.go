@@ -16,13 +16,22 @@ import (
 // result or error per JSON-RPC spec, which is forwarded unchanged.
 type WRPDispatcher struct {
 	Client      *WRPClient
-	Source      string // e.g., "blizzard/gateway"
-	Dest        string // device destination (logical) optional for now
-	ServiceName string // optional path/service identifier
+	Source      string     // e.g., "blizzard/gateway"
+	Dest        string     // device destination (logical) optional for now
+	ServiceName string     // optional path/service identifier
+	Gate        DeviceGate // optional; fails calls fast while the device is offline
+	// Latency, if set, records the round trip of every call, counting a
+	// timed-out one as taking its whole budget.
+	Latency *LatencyTracker
+	// Retry, if set, repeats transient failures of idempotent methods.
+	Retry *RetryPolicy
+	// Timeout bounds calls whose ctx carries no deadline (default 8s); a
+	// caller's deadline, e.g. from the gateway's timeout policy, wins.
+	Timeout time.Duration
 }
 
-// Handle implements Dispatcher.
-func (w *WRPDispatcher) Handle(r *Request) *Response {
+// Handle implements Dispatcher; cancelling ctx aborts the upstream call.
+func (w *WRPDispatcher) Handle(ctx context.Context, r *Request) *Response {
 	// Marshal request back to JSON for embedding in WRP content.
 	raw, err := json.Marshal(r)
 	if err != nil {
@@ -38,14 +47,42 @@ func (w *WRPDispatcher) Handle(r *Request) *Response {
 		ContentType:     "application/json",
 		Payload:         raw,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
-	upstream, err := w.Client.Do(ctx, msg)
+	device := destDevice(w.Dest)
+	ctx, done, ok := beginDevice(w.Gate, ctx, device)
+	if !ok {
+		return deviceOfflineError(r, device)
+	}
+	defer done()
+	if _, ok := ctx.Deadline(); !ok {
+		timeout := w.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	retry := w.Retry.retrier(r.Method)
+	upstream, err := retry.send(ctx, w.ServiceName, func(ctx context.Context) (*wrp.Message, error) {
+		start := time.Now()
+		upstream, err := w.Client.Do(ctx, msg)
+		w.Latency.observeAttempt(ctx, device, r.Method, start, err)
+		return upstream, err
+	})
 	if err != nil {
+		if deviceOffline(w.Gate, ctx, device, err) {
+			return deviceOfflineError(r, device)
+		}
+		if retry.enabled() {
+			return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32100, Message: "transport error", Data: map[string]any{"dest": w.Dest, "attempts": retry.history}}}
+		}
 		// Enrich Data with destination for debugging
 		detail := fmt.Sprintf("dest=%s service=%s err=%s", w.Dest, w.ServiceName, err.Error())
 		return &Response{JSONRPC: "2.0", ID: r.ID, Error: &Error{Code: -32100, Message: "transport error", Data: detail}}
 	}
+	if w.Gate != nil {
+		w.Gate.Seen(device)
+	}
 	// Attempt to parse upstream payload as JSON-RPC response; if that fails treat as raw result.
 	var jr Response
 	if err := json.Unmarshal(upstream.Payload, &jr); err == nil && jr.JSONRPC == "2.0" { // well-formed response
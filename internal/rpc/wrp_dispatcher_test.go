@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
@@ -42,7 +43,7 @@ func TestWRPDispatcherRoundTrip(t *testing.T) {
 
 	// Build JSON-RPC request
 	req := &Request{JSONRPC: "2.0", ID: json.RawMessage(`"abc123"`), Method: "Device.Ping"}
-	resp := d.Handle(req)
+	resp := d.Handle(context.Background(), req)
 	if resp == nil || resp.Error != nil {
 		t.Fatalf("expected success response, got %+v", resp)
 	}
@@ -19,6 +19,7 @@ type Config struct {
 	Bucket         string
 	AuthBasic      string
 	CallbackURL    string
+	Secret         string // Caduceus signs deliveries with it; see Handler
 	Events         []string
 	DeviceMatchers []string
 	Duration       time.Duration // retains for documentation (not used directly by Argus storage)
@@ -44,6 +45,7 @@ type WebhookData struct {
 type LegacyWebhookConfig struct {
 	URL         string `json:"url"`
 	ContentType string `json:"content_type"`
+	Secret      string `json:"secret,omitempty"`
 }
 
 // LegacyWebhookMatcher represents device ID matching in legacy format
@@ -110,6 +112,7 @@ func (c Config) Register() {
 		Config: LegacyWebhookConfig{
 			URL:         c.CallbackURL,
 			ContentType: "application/msgpack",
+			Secret:      c.Secret,
 		},
 		Events: events,
 		Matcher: LegacyWebhookMatcher{
@@ -2,7 +2,12 @@ package webhook
 
 import (
 	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"hash"
 	"io"
 	"log"
 	"net/http"
@@ -11,7 +16,9 @@ import (
 
 	wrp "github.com/xmidt-org/wrp-go/v3"
 
+	"github.com/stepherg/blizzardgw/internal/deviceid"
 	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
 )
 
 // IncomingEvent is a liberal structure for device events. Adjust as upstream schema firms up.
@@ -22,9 +29,27 @@ type IncomingEvent struct {
 	Payload json.RawMessage `json:"payload"`
 }
 
-// Handler returns an http.HandlerFunc that ingests POSTed webhook events.
-// TODO: Add signature validation (HMAC / JWT) once security model decided.
-func Handler(bus *events.Bus) http.HandlerFunc {
+// signatureHeader carries the HMAC of the body, keyed with the secret the
+// webhook was registered with: "sha1=<hex>" from legacy registrations,
+// "sha256=<hex>" from ancla's.
+const signatureHeader = "X-Webpa-Signature"
+
+// reservedPrefix marks the gateway's own event names (presence's
+// gateway.device.online/offline among them), which webhook deliveries may
+// not use.
+const reservedPrefix = "gateway."
+
+// Handler returns an http.HandlerFunc that ingests POSTed webhook events,
+// publishing device events on bus and handing Talaria device-status events to
+// pres, which publishes the changes itself. With a secret, deliveries must
+// carry a valid X-Webpa-Signature and others are refused. Without one anybody
+// can post events, so none of them feed presence: device-status events are
+// acknowledged and dropped and other events don't mark their device seen,
+// leaving the device gate to Scytale's answers.
+func Handler(bus *events.Bus, pres *presence.Table, secret string) http.HandlerFunc {
+	if secret == "" {
+		pres = nil
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -36,6 +61,10 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 			return
 		}
 		_ = r.Body.Close()
+		if secret != "" && !validSignature(r.Header.Get(signatureHeader), body, secret) {
+			http.Error(w, "invalid signature", http.StatusUnauthorized)
+			return
+		}
 
 		// Check Content-Type to determine if this is a WRP msgpack message
 		contentType := r.Header.Get("Content-Type")
@@ -44,6 +73,23 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 			dec := wrp.NewDecoder(bytes.NewReader(body), wrp.Msgpack)
 			var msg wrp.Message
 			if err := dec.Decode(&msg); err == nil {
+				// Talaria device-status online/offline events feed device presence.
+				if st, ok := presence.ParseStatusEvent(&msg); ok {
+					if secret == "" {
+						log.Printf("webhook.debug ts=%s path=%s device=%s online=%t unsigned status event ignored (set WEBHOOK_SECRET)",
+							time.Now().Format(time.RFC3339Nano), r.URL.Path, st.Device, st.Online)
+						w.WriteHeader(http.StatusAccepted)
+						return
+					}
+					if pres != nil {
+						pres.Update(st)
+					}
+					log.Printf("webhook.debug ts=%s path=%s device=%s online=%t session_id=%s reason=%q",
+						time.Now().Format(time.RFC3339Nano), r.URL.Path, st.Device, st.Online, st.SessionID, st.Reason)
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+
 				// Extract device ID from WRP source (format: "mac:xxxx/service")
 				device := extractDeviceFromSource(msg.Source)
 				service := extractServiceFromSource(msg.Source)
@@ -56,6 +102,10 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 				if eventName == "" {
 					eventName = "Unknown"
 				}
+				if reserved(eventName) {
+					http.Error(w, "reserved event name", http.StatusBadRequest)
+					return
+				}
 
 				// The payload contains the actual JSON-RPC message
 				// Publish it as-is (it's already JSON)
@@ -65,6 +115,7 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 					Name:    eventName,
 					Payload: msg.Payload,
 				})
+				seen(pres, device)
 
 				log.Printf("webhook.debug ts=%s path=%s device=%s service=%s name=%s wrp=1 payload_bytes=%d payload_preview=%q",
 					time.Now().Format(time.RFC3339Nano), r.URL.Path, device, service, eventName,
@@ -79,7 +130,13 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 		// Content may be either JSON object or raw binary (e.g., USP). Try JSON first.
 		var evt IncomingEvent
 		if json.Unmarshal(body, &evt) == nil && evt.Device != "" && evt.Name != "" { // JSON form recognized
+			if reserved(evt.Name) {
+				http.Error(w, "reserved event name", http.StatusBadRequest)
+				return
+			}
+			evt.Device = deviceid.Normalize(evt.Device, "")
 			bus.Publish(events.Event{Device: evt.Device, Service: evt.Service, Name: evt.Name, Payload: evt.Payload})
+			seen(pres, evt.Device)
 			// Debug log (structured-ish): JSON path
 			log.Printf("webhook.debug ts=%s path=%s device=%s service=%s name=%s json=1 payload_bytes=%d payload_preview=%q", time.Now().Format(time.RFC3339Nano), r.URL.Path, evt.Device, nz(evt.Service, "BlizzardRDK"), evt.Name, len(evt.Payload), previewBytes(evt.Payload, 256))
 			w.WriteHeader(http.StatusAccepted)
@@ -94,18 +151,54 @@ func Handler(bus *events.Bus) http.HandlerFunc {
 		if name == "" {
 			name = "Unknown"
 		}
+		if reserved(name) {
+			http.Error(w, "reserved event name", http.StatusBadRequest)
+			return
+		}
 		service := r.Header.Get("X-Service")
 		if service == "" {
 			service = "BlizzardRDK"
 		}
 		// Normalize
-		device = strings.TrimSpace(device)
+		device = deviceid.Normalize(device, "")
 		bus.Publish(events.Event{Device: device, Service: service, Name: name, Payload: body})
+		seen(pres, device)
 		log.Printf("webhook.debug ts=%s path=%s device=%s service=%s name=%s json=0 payload_bytes=%d payload_preview=%q", time.Now().Format(time.RFC3339Nano), r.URL.Path, device, service, name, len(body), previewBytes(body, 256))
 		w.WriteHeader(http.StatusAccepted)
 	}
 }
 
+// seen marks device online in pres: any event from a device shows it is
+// connected.
+func seen(pres *presence.Table, device string) {
+	if pres != nil && device != "" {
+		pres.Seen(device)
+	}
+}
+
+// reserved reports whether name is one of the gateway's own event names.
+func reserved(name string) bool {
+	return len(name) >= len(reservedPrefix) && strings.EqualFold(name[:len(reservedPrefix)], reservedPrefix)
+}
+
+// validSignature reports whether sig is "sha1=<hex>" or "sha256=<hex>" of
+// the HMAC of body keyed with secret.
+func validSignature(sig string, body []byte, secret string) bool {
+	algo, got, _ := strings.Cut(sig, "=")
+	var mac hash.Hash
+	switch strings.ToLower(algo) {
+	case "sha1":
+		mac = hmac.New(sha1.New, []byte(secret))
+	case "sha256":
+		mac = hmac.New(sha256.New, []byte(secret))
+	default:
+		return false
+	}
+	mac.Write(body)
+	want := hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(strings.ToLower(got)), []byte(want))
+}
+
 // previewBytes returns a printable (possibly truncated) string representation of raw bytes.
 func previewBytes(b []byte, max int) string {
 	if len(b) == 0 {
@@ -125,7 +218,7 @@ func nz(s, fallback string) string {
 	return s
 }
 
-// extractDeviceFromSource extracts the device ID from WRP source field.
+// extractDeviceFromSource extracts the canonical device ID from WRP source field.
 // Source format: "mac:112233445566/service" or "mac:112233445566"
 func extractDeviceFromSource(source string) string {
 	if source == "" {
@@ -133,10 +226,7 @@ func extractDeviceFromSource(source string) string {
 	}
 	// Split on '/' to separate device from service
 	parts := strings.Split(source, "/")
-	if len(parts) > 0 {
-		return parts[0]
-	}
-	return source
+	return deviceid.Normalize(parts[0], "")
 }
 
 // extractServiceFromSource extracts the service name from WRP source field.
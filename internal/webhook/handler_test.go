@@ -1,7 +1,19 @@
 package webhook
 
 import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	wrp "github.com/xmidt-org/wrp-go/v3"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
 )
 
 func TestExtractDeviceFromSource(t *testing.T) {
@@ -12,6 +24,8 @@ func TestExtractDeviceFromSource(t *testing.T) {
 	}{
 		{"with service", "mac:06cbd937c9d2/BlizzardRDK", "mac:06cbd937c9d2"},
 		{"without service", "mac:06cbd937c9d2", "mac:06cbd937c9d2"},
+		{"canonicalized mac", "MAC:06:CB:D9:37:C9:D2/BlizzardRDK", "mac:06cbd937c9d2"},
+		{"serial", "serial:X1234/BlizzardRDK", "serial:X1234"},
 		{"empty", "", ""},
 	}
 
@@ -68,3 +82,128 @@ func TestExtractEventFromDestination(t *testing.T) {
 		})
 	}
 }
+
+const testSecret = "hook-secret"
+
+// statusRequest builds a signed Talaria offline event delivery.
+func statusRequest(t *testing.T, secret string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:06cbd937c9d2", Destination: "event:device-status/mac:06cbd937c9d2/offline", Payload: []byte(`{"id":"mac:06cbd937c9d2","reason-for-closure":"ping miss"}`)}
+	if err := wrp.NewEncoder(&body, wrp.Msgpack).Encode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body.Bytes())
+	req := httptest.NewRequest(http.MethodPost, "/webhook/events", &body)
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set(signatureHeader, "sha1="+hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandlerDeviceStatusEvent(t *testing.T) {
+	bus := events.NewBus()
+	_, ch, cancel := bus.Subscribe(1)
+	defer cancel()
+	pres := presence.NewTable()
+	pres.Bus = bus
+	rec := httptest.NewRecorder()
+	Handler(bus, pres, testSecret)(rec, statusRequest(t, testSecret))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+	if s, ok := pres.Get("mac:06cbd937c9d2"); !ok || s.Online || s.Reason != "ping miss" {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+	ev := <-ch
+	if ev.Name != presence.DeviceOffline || ev.Device != "mac:06cbd937c9d2" || !strings.Contains(string(ev.Payload), `"reason":"ping miss"`) {
+		t.Fatalf("unexpected event: %+v %s", ev, ev.Payload)
+	}
+}
+
+func TestHandlerRejectsReservedNames(t *testing.T) {
+	bus := events.NewBus()
+	_, ch, cancel := bus.Subscribe(1)
+	defer cancel()
+	pres := presence.NewTable()
+
+	var wrpBody bytes.Buffer
+	msg := wrp.Message{Type: wrp.SimpleEventMessageType, Source: "mac:06cbd937c9d2", Destination: "event:x/gateway.device.offline", Payload: []byte(`{}`)}
+	if err := wrp.NewEncoder(&wrpBody, wrp.Msgpack).Encode(&msg); err != nil {
+		t.Fatal(err)
+	}
+	reqs := map[string]*http.Request{
+		"msgpack": httptest.NewRequest(http.MethodPost, "/webhook/events", &wrpBody),
+		"json":    httptest.NewRequest(http.MethodPost, "/webhook/events", strings.NewReader(`{"device":"mac:06cbd937c9d2","name":"Gateway.Device.Offline","payload":{}}`)),
+		"header":  httptest.NewRequest(http.MethodPost, "/webhook/events", strings.NewReader(`{}`)),
+	}
+	reqs["msgpack"].Header.Set("Content-Type", "application/msgpack")
+	reqs["header"].Header.Set("X-Xmidt-Device", "mac:06cbd937c9d2")
+	reqs["header"].Header.Set("X-Event-Name", presence.DeviceOffline)
+	for name, req := range reqs {
+		rec := httptest.NewRecorder()
+		Handler(bus, pres, "")(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", name, rec.Code)
+		}
+	}
+	select {
+	case ev := <-ch:
+		t.Fatalf("reserved event published: %+v", ev)
+	default:
+	}
+	if _, ok := pres.Get("mac:06cbd937c9d2"); ok {
+		t.Fatal("reserved event reached presence")
+	}
+}
+
+func TestHandlerRejectsForgedStatusEvents(t *testing.T) {
+	bus := events.NewBus()
+	_, ch, cancel := bus.Subscribe(1)
+	defer cancel()
+	pres := presence.NewTable()
+	pres.Bus = bus
+
+	// A wrong signature is refused outright.
+	rec := httptest.NewRecorder()
+	Handler(bus, pres, testSecret)(rec, statusRequest(t, "guess"))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a bad signature, got %d", rec.Code)
+	}
+	// Neither is an unsupported or missing algorithm.
+	req := statusRequest(t, testSecret)
+	req.Header.Set(signatureHeader, strings.Replace(req.Header.Get(signatureHeader), "sha1=", "md5=", 1))
+	rec = httptest.NewRecorder()
+	Handler(bus, pres, testSecret)(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for an unsupported algorithm, got %d", rec.Code)
+	}
+	// Without a secret the delivery is accepted but cannot mark the device offline.
+	rec = httptest.NewRecorder()
+	Handler(bus, pres, "")(rec, statusRequest(t, ""))
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+	select {
+	case ev := <-ch:
+		t.Fatalf("unauthenticated status event published: %+v", ev)
+	default:
+	}
+	if _, ok := pres.Get("mac:06cbd937c9d2"); ok {
+		t.Fatal("unauthenticated status event reached presence")
+	}
+}
+
+func TestUnsignedEventsDoNotFeedPresence(t *testing.T) {
+	pres := presence.NewTable()
+	pres.Update(presence.Status{Device: "mac:06cbd937c9d2", Reason: "ping miss"})
+	req := httptest.NewRequest(http.MethodPost, "/webhook/events", strings.NewReader(`{"device":"mac:06cbd937c9d2","name":"Time.TimerElapsed","payload":{}}`))
+	rec := httptest.NewRecorder()
+	Handler(events.NewBus(), pres, "")(rec, req)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected 202, got %d", rec.Code)
+	}
+	if s, _ := pres.Get("mac:06cbd937c9d2"); s.Online {
+		t.Fatal("unsigned event marked the device online")
+	}
+}
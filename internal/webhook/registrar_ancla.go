@@ -122,6 +122,8 @@ func (c Config) RegisterAncla(ctx context.Context) {
 				{
 					ReceiverURLs: []string{c.CallbackURL},
 					Accept:       "application/msgpack",
+					Secret:       c.Secret,
+					SecretHash:   "sha256",
 				},
 			},
 			Matcher: matchers,
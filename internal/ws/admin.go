@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+)
+
+// AdminHandler returns an http.HandlerFunc serving the sessions admin API,
+// mounted at /admin/sessions:
+//
+//	GET    /admin/sessions[?device=&service=&remote=]  list live connections
+//	DELETE /admin/sessions/<id>[?reason=...]           force-disconnect one
+//
+// Requests must carry "Authorization: Bearer <token>". An empty token
+// disables the API: every request is refused.
+func AdminHandler(reg *Registry, token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuthorized(w, r, token) {
+			return
+		}
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/sessions"), "/")
+		switch {
+		case r.Method == http.MethodGet && id == "":
+			q := r.URL.Query()
+			list := reg.List(SessionFilter{Device: q.Get("device"), Service: q.Get("service"), RemoteAddr: q.Get("remote")})
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"count": len(list), "sessions": list})
+		case r.Method == http.MethodDelete && id != "":
+			reason := r.URL.Query().Get("reason")
+			if reason == "" {
+				reason = "disconnected by administrator"
+			}
+			if !reg.Disconnect(id, websocket.ClosePolicyViolation, reason) {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// AdminOnly wraps next, such as expvar.Handler() for /debug/vars, behind the
+// admin API's bearer token. An empty token refuses every request.
+func AdminOnly(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if adminAuthorized(w, r, token) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// adminAuthorized checks r for "Authorization: Bearer <token>", answering
+// it with an error and returning false when it is missing or wrong.
+func adminAuthorized(w http.ResponseWriter, r *http.Request, token string) bool {
+	if token == "" {
+		http.Error(w, "admin API disabled", http.StatusForbidden)
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
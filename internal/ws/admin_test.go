@@ -0,0 +1,135 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestAdminSessions(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	bound, _ := dialURL(t, wsURL+"/ws/mac:112233445566/BlizzardRDK")
+	dialURL(t, wsURL+"/ws")
+
+	admin := httptest.NewServer(AdminHandler(h.Registry(), "secret"))
+	defer admin.Close()
+	do := func(method, path, token string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, admin.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	if resp := do(http.MethodGet, "/admin/sessions", ""); resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without token, got %d", resp.StatusCode)
+	}
+	var list struct {
+		Count    int           `json:"count"`
+		Sessions []SessionInfo `json:"sessions"`
+	}
+	resp := do(http.MethodGet, "/admin/sessions?device=112233445566", "secret")
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if list.Count != 1 || list.Sessions[0].Device != "mac:112233445566" || list.Sessions[0].Service != "BlizzardRDK" {
+		t.Fatalf("unexpected device filter result: %+v", list)
+	}
+	var raw struct {
+		Sessions []map[string]any `json:"sessions"`
+	}
+	if err := json.NewDecoder(do(http.MethodGet, "/admin/sessions", "secret").Body).Decode(&raw); err != nil || len(raw.Sessions) != 2 {
+		t.Fatalf("list all: %v %+v", err, raw)
+	}
+	for _, s := range raw.Sessions {
+		if _, ok := s["session"]; ok {
+			t.Fatalf("listing leaks a resume token: %v", s)
+		}
+	}
+
+	if resp := do(http.MethodDelete, "/admin/sessions/"+list.Sessions[0].ID+"?reason=maintenance", "secret"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204, got %d", resp.StatusCode)
+	}
+	_ = bound.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := bound.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || ce.Text != "maintenance" {
+		t.Fatalf("expected policy-violation close with reason, got %v", err)
+	}
+	if resp := do(http.MethodDelete, "/admin/sessions/unknown", "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown session, got %d", resp.StatusCode)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Registry().Len() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := h.Registry().Len(); n != 1 {
+		t.Fatalf("expected disconnected session to leave the registry, %d remain", n)
+	}
+}
+
+func TestAdminDisabledWithoutToken(t *testing.T) {
+	admin := httptest.NewServer(AdminHandler(newRegistry(), ""))
+	defer admin.Close()
+	resp, err := http.Get(admin.URL + "/admin/sessions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 without a configured token, got %d", resp.StatusCode)
+	}
+}
+
+func TestAdminOnly(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range []struct {
+		token, auth string
+		want        int
+	}{
+		{"", "Bearer ", http.StatusForbidden},
+		{"admin-secret", "", http.StatusUnauthorized},
+		{"admin-secret", "Bearer guess", http.StatusUnauthorized},
+		{"admin-secret", "Bearer admin-secret", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+		req.Header.Set("Authorization", tt.auth)
+		rec := httptest.NewRecorder()
+		AdminOnly(tt.token, ok).ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("token %q auth %q: got %d, want %d", tt.token, tt.auth, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestDisconnectTruncatesLongReason(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}}
+	c := dialTest(t, h)
+	reason := strings.Repeat("é", 100) // 200 bytes
+	if !h.Registry().Disconnect(h.Registry().List(SessionFilter{})[0].ID, websocket.ClosePolicyViolation, reason) {
+		t.Fatal("session not found")
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, _, err := c.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.ClosePolicyViolation || len(ce.Text) != 122 || !strings.HasPrefix(reason, ce.Text) {
+		t.Fatalf("expected the reason cut to 122 bytes on a rune boundary, got %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+)
+
+// Upgrade-request headers that bind a connection to a device and service.
+const (
+	headerDeviceID = "X-Device-ID"
+	headerService  = "X-Service"
+)
+
+// binding is the device/service a connection is bound to ("" device when unbound).
+type binding struct {
+	device  string
+	service string
+}
+
+// bindingSource is one place a client may name its binding, for error messages.
+type bindingSource struct {
+	name string
+	binding
+}
+
+// parseBinding reads the connection's binding from the path
+// (/ws/<device>[/<service>], segments may be percent-encoded), the device and
+// service query parameters, or the X-Device-ID/X-Service headers. Sources
+// may repeat or complement one another but must agree; a service without a
+// device, an extra path segment or an empty path device is an error. Paths
+// outside /ws/ are unbound.
+func parseBinding(r *http.Request) (binding, error) {
+	var sources []bindingSource
+	segs := strings.Split(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if len(segs) >= 2 && segs[0] == "ws" && (len(segs) > 2 || segs[1] != "") {
+		if len(segs) > 3 {
+			return binding{}, errors.New("path must be /ws/<device>[/<service>]; percent-encode '/' inside a device id")
+		}
+		var b binding
+		var err error
+		if b.device, err = url.PathUnescape(segs[1]); err != nil {
+			return binding{}, fmt.Errorf("path: %w", err)
+		}
+		if len(segs) == 3 {
+			if b.service, err = url.PathUnescape(segs[2]); err != nil {
+				return binding{}, fmt.Errorf("path: %w", err)
+			}
+		}
+		sources = append(sources, bindingSource{"path", b})
+	}
+	q := r.URL.Query()
+	sources = append(sources,
+		bindingSource{"query", binding{q.Get("device"), q.Get("service")}},
+		bindingSource{"header", binding{r.Header.Get(headerDeviceID), r.Header.Get(headerService)}},
+	)
+
+	var out binding
+	var deviceFrom, serviceFrom string
+	for _, s := range sources {
+		device, service := strings.TrimSpace(s.device), strings.TrimSpace(s.service)
+		switch {
+		case device == "" && s.name == "path":
+			return binding{}, errors.New("path: empty device")
+		case device == "":
+		case out.device == "":
+			out.device, deviceFrom = device, s.name
+		case !sameDevice(out.device, device):
+			return binding{}, fmt.Errorf("ambiguous binding: %s device %q conflicts with %s device %q", deviceFrom, out.device, s.name, device)
+		}
+		switch {
+		case service == "":
+		case out.service == "":
+			out.service, serviceFrom = service, s.name
+		case service != out.service:
+			return binding{}, fmt.Errorf("ambiguous binding: %s service %q conflicts with %s service %q", serviceFrom, out.service, s.name, service)
+		}
+	}
+	if out.device == "" && out.service != "" {
+		return binding{}, fmt.Errorf("%s: service %q given without a device", serviceFrom, out.service)
+	}
+	return out, nil
+}
+
+// sameDevice compares ids in canonical form; bare ids are compared as MACs
+// since no route has been resolved yet.
+func sameDevice(a, b string) bool {
+	return strings.EqualFold(deviceid.Normalize(a, deviceid.SchemeMAC), deviceid.Normalize(b, deviceid.SchemeMAC))
+}
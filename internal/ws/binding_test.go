@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestParseBinding(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		headers map[string]string
+		want    binding
+		wantErr string
+	}{
+		{"unbound root", "/", nil, binding{}, ""},
+		{"unbound ws", "/ws", nil, binding{}, ""},
+		{"path", "/ws/mac:112233445566/BlizzardRDK", nil, binding{"mac:112233445566", "BlizzardRDK"}, ""},
+		{"path device only", "/ws/112233445566", nil, binding{"112233445566", ""}, ""},
+		{"path encoded slash", "/ws/serial:AB%2F12/BlizzardRDK", nil, binding{"serial:AB/12", "BlizzardRDK"}, ""},
+		{"query", "/ws?device=serial:AB/12&service=BlizzardRDK", nil, binding{"serial:AB/12", "BlizzardRDK"}, ""},
+		{"headers", "/gateway", map[string]string{headerDeviceID: "AA:BB:CC:DD:EE:FF", headerService: "BlizzardRDK"}, binding{"AA:BB:CC:DD:EE:FF", "BlizzardRDK"}, ""},
+		{"complementing sources", "/ws/aabbccddeeff?device=mac:AA-BB-CC-DD-EE-FF&service=BlizzardRDK", nil, binding{"aabbccddeeff", "BlizzardRDK"}, ""},
+		{"conflicting devices", "/ws/aabbccddeeff", map[string]string{headerDeviceID: "112233445566"}, binding{}, "ambiguous"},
+		{"conflicting services", "/ws/aabbccddeeff/A?service=B", nil, binding{}, "ambiguous"},
+		{"service without device", "/ws?service=BlizzardRDK", nil, binding{}, "without a device"},
+		{"extra path segment", "/ws/serial:AB/12/BlizzardRDK", nil, binding{}, "percent-encode"},
+		{"empty path device", "/ws//BlizzardRDK?device=aabbccddeeff", nil, binding{}, "empty device"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "http://gw"+tt.target, nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			got, err := parseBinding(r)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("parseBinding = %+v, %v; want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestBindingRejectedBeforeUpgrade(t *testing.T) {
+	srv := httptest.NewServer(&Handler{Dispatcher: rpc.EchoDispatcher{}})
+	defer srv.Close()
+	for _, path := range []string{"/ws/mac:xyz/BlizzardRDK", "/ws?service=BlizzardRDK"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestUnboundWRPRequiresTarget(t *testing.T) {
+	srv, seen := newScytale(t)
+	c := dialTest(t, &Handler{Dispatcher: &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: srv.URL}, Source: "blizzard/gateway"}})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Ping"})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("expected invalid params, got %+v", resp)
+	}
+	if n := len(seen()); n != 0 {
+		t.Fatalf("expected nothing sent upstream, got %d messages", n)
+	}
+}
@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// cancelMethod is the client notification that aborts an in-flight request.
+// Params: {"id": <id of the request to cancel>}.
+const cancelMethod = "$/cancelRequest"
+
+// errRequestCanceled is the cancellation cause recorded by $/cancelRequest,
+// distinguishing it from the connection closing.
+var errRequestCanceled = errors.New("request cancelled")
+
+// pendingCalls tracks cancel functions for in-flight requests keyed by id.
+type pendingCalls struct {
+	mu sync.Mutex
+	m  map[string]context.CancelCauseFunc
+}
+
+func (p *pendingCalls) add(key string, cancel context.CancelCauseFunc) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.m == nil {
+		p.m = make(map[string]context.CancelCauseFunc)
+	}
+	p.m[key] = cancel
+}
+
+func (p *pendingCalls) remove(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.m, key)
+}
+
+func (p *pendingCalls) cancel(key string) bool {
+	p.mu.Lock()
+	cancel, ok := p.m[key]
+	p.mu.Unlock()
+	if ok {
+		cancel(errRequestCanceled)
+	}
+	return ok
+}
+
+// idKey normalizes a JSON-RPC id so the cancel notification matches the
+// original request regardless of whitespace.
+func idKey(id json.RawMessage) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, id); err != nil {
+		return string(id)
+	}
+	return buf.String()
+}
+
+// track derives a per-request context from the connection's so r can be
+// cancelled individually or when the socket closes. It is called from the
+// read loop, before dispatch, so a cancel arriving right behind the request
+// always finds it. The returned func releases the registration.
+func (c *client) track(r *rpc.Request) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(c.ctx)
+	if r.IsNotification() {
+		return ctx, func() { cancel(nil) }
+	}
+	key := idKey(r.ID)
+	c.pending.add(key, cancel)
+	return ctx, func() {
+		c.pending.remove(key)
+		cancel(nil)
+	}
+}
+
+// call dispatches r under ctx (from track). A request cancelled by
+// $/cancelRequest is answered with CodeRequestCanceled.
+func (c *client) call(ctx context.Context, d rpc.Dispatcher, r *rpc.Request) *rpc.Response {
+	resp := d.Handle(ctx, r)
+	if errors.Is(context.Cause(ctx), errRequestCanceled) {
+		return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Error: &rpc.Error{Code: rpc.CodeRequestCanceled, Message: "request cancelled"}}
+	}
+	return resp
+}
+
+// cancelRequest implements the $/cancelRequest notification. Unknown ids are
+// ignored: the request may already have completed.
+func (c *client) cancelRequest(r *rpc.Request) {
+	var p struct {
+		ID json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(r.Params, &p); err != nil || len(p.ID) == 0 {
+		return
+	}
+	c.pending.cancel(idKey(p.ID))
+}
@@ -0,0 +1,53 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// blockingDispatcher waits until the request context is done, reporting
+// each cancellation on canceled.
+type blockingDispatcher struct{ canceled chan error }
+
+func (b blockingDispatcher) Handle(ctx context.Context, r *rpc.Request) *rpc.Response {
+	select {
+	case <-ctx.Done():
+		b.canceled <- ctx.Err()
+		return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Error: &rpc.Error{Code: rpc.CodeTransportError, Message: "transport error"}}
+	case <-time.After(5 * time.Second):
+		return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: "late"}
+	}
+}
+
+func TestCancelRequest(t *testing.T) {
+	d := blockingDispatcher{canceled: make(chan error, 1)}
+	c := dialTest(t, &Handler{Dispatcher: d})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": "abc", "method": "Device.Slow"})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "$/cancelRequest", "params": map[string]any{"id": "abc"}})
+
+	resp := readResponse(t, c)
+	if string(resp.ID) != `"abc"` || resp.Error == nil || resp.Error.Code != rpc.CodeRequestCanceled {
+		t.Fatalf("expected cancelled error for abc, got %+v", resp)
+	}
+	select {
+	case <-d.canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("dispatcher context was not cancelled")
+	}
+}
+
+func TestCloseCancelsPending(t *testing.T) {
+	d := blockingDispatcher{canceled: make(chan error, 1)}
+	c := dialTest(t, &Handler{Dispatcher: d})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Slow"})
+	time.Sleep(50 * time.Millisecond) // let the request reach the dispatcher
+	c.Close()
+	select {
+	case <-d.canceled:
+	case <-time.After(time.Second):
+		t.Fatalf("closing the socket did not cancel the pending call")
+	}
+}
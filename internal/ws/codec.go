@@ -0,0 +1,108 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+
+	"github.com/gorilla/websocket"
+	"github.com/ugorji/go/codec"
+)
+
+// Supported WebSocket subprotocols. A client that negotiates none gets the
+// JSON behaviour (text frames; binary frames parsed as JSON).
+const (
+	SubprotocolJSON    = "jsonrpc2.json"
+	SubprotocolMsgpack = "jsonrpc2.msgpack"
+)
+
+// Subprotocols lists the subprotocols offered during the upgrade, in
+// server preference order.
+var Subprotocols = []string{SubprotocolJSON, SubprotocolMsgpack}
+
+// frameCodec converts between WebSocket frames and the JSON used internally
+// (dispatchers, session buffers and the send queue all carry JSON).
+type frameCodec interface {
+	// decode returns the JSON form of an inbound data frame.
+	decode(messageType int, data []byte) ([]byte, error)
+	// encode returns the frame type and payload for an outbound JSON message.
+	encode(data []byte) (int, []byte, error)
+}
+
+func codecFor(subprotocol string) frameCodec {
+	if subprotocol == SubprotocolMsgpack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+// jsonCodec passes JSON through as text frames.
+type jsonCodec struct{}
+
+func (jsonCodec) decode(_ int, data []byte) ([]byte, error) { return data, nil }
+
+func (jsonCodec) encode(data []byte) (int, []byte, error) {
+	return websocket.TextMessage, data, nil
+}
+
+// msgpackHandle mirrors the msgpack encoding the gateway already uses with
+// Scytale (wrp.Msgpack): maps decode with string keys and raw strings stay
+// strings so the JSON conversion round-trips.
+var msgpackHandle = func() *codec.MsgpackHandle {
+	h := &codec.MsgpackHandle{}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.RawToString = true
+	h.WriteExt = true
+	return h
+}()
+
+// msgpackCodec carries messages as binary msgpack frames. Text frames are
+// still accepted as JSON.
+type msgpackCodec struct{}
+
+func (msgpackCodec) decode(messageType int, data []byte) ([]byte, error) {
+	if messageType == websocket.TextMessage {
+		return data, nil
+	}
+	var v interface{}
+	if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+func (msgpackCodec) encode(data []byte) (int, []byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // keep integer ids integral rather than float64
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return 0, nil, err
+	}
+	var out []byte
+	if err := codec.NewEncoderBytes(&out, msgpackHandle).Encode(fromJSONNumbers(v)); err != nil {
+		return 0, nil, err
+	}
+	return websocket.BinaryMessage, out, nil
+}
+
+// fromJSONNumbers replaces json.Number values with int64 or float64 so they
+// encode as msgpack numbers rather than strings.
+func fromJSONNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = fromJSONNumbers(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = fromJSONNumbers(e)
+		}
+	}
+	return v
+}
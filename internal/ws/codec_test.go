@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+	"github.com/ugorji/go/codec"
+)
+
+func TestMsgpackSubprotocol(t *testing.T) {
+	srv := httptest.NewServer(&Handler{Dispatcher: rpc.EchoDispatcher{}})
+	defer srv.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{SubprotocolMsgpack}}
+	c, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/ws", nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer c.Close()
+	if c.Subprotocol() != SubprotocolMsgpack {
+		t.Fatalf("negotiated %q, want %q", c.Subprotocol(), SubprotocolMsgpack)
+	}
+
+	read := func() map[string]interface{} {
+		t.Helper()
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		mt, data, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if mt != websocket.BinaryMessage {
+			t.Fatalf("expected binary frame, got type %d", mt)
+		}
+		var v map[string]interface{}
+		if err := codec.NewDecoderBytes(data, msgpackHandle).Decode(&v); err != nil {
+			t.Fatalf("decode msgpack: %v", err)
+		}
+		return v
+	}
+	if note := read(); note["method"] != sessionMethod {
+		t.Fatalf("expected session notification, got %v", note)
+	}
+
+	var req []byte
+	_ = codec.NewEncoderBytes(&req, msgpackHandle).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 7, "method": "Device.Ping"})
+	if err := c.WriteMessage(websocket.BinaryMessage, req); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp := read()
+	if id, ok := resp["id"].(int64); !ok || id != 7 {
+		t.Fatalf("expected integer id 7, got %#v", resp["id"])
+	}
+	result, _ := resp["result"].(map[string]interface{})
+	if result["method"] != "Device.Ping" {
+		t.Fatalf("unexpected result %#v", resp["result"])
+	}
+}
+
+func TestJSONSubprotocolDefault(t *testing.T) {
+	if _, ok := codecFor("").(jsonCodec); !ok {
+		t.Fatalf("no subprotocol should select JSON framing")
+	}
+	if _, ok := codecFor(SubprotocolJSON).(jsonCodec); !ok {
+		t.Fatalf("%s should select JSON framing", SubprotocolJSON)
+	}
+}
@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// ConnLimits caps concurrent WebSocket connections. Zero fields are unlimited.
+type ConnLimits struct {
+	Total     int // across the gateway
+	PerIP     int // per client IP
+	PerDevice int // bound to the same device via /ws/<device>/<service>
+}
+
+// connLimitRetryAfter is the Retry-After (seconds) sent with a rejected upgrade.
+const connLimitRetryAfter = "5"
+
+// connCounts tracks reserved connection slots. Slots are taken before the
+// upgrade so concurrent handshakes cannot overshoot a limit.
+type connCounts struct {
+	mu       sync.Mutex
+	total    int
+	byIP     map[string]int
+	byDevice map[string]int
+}
+
+// reserve takes a slot for ip/device (device "" for unbound connections).
+// On success release must be called exactly once when the connection ends;
+// otherwise status and reason describe the limit that was hit.
+func (cc *connCounts) reserve(l ConnLimits, ip, device string) (release func(), status int, reason string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	switch {
+	case l.Total > 0 && cc.total >= l.Total:
+		return nil, http.StatusServiceUnavailable, fmt.Sprintf("gateway connection limit reached (%d)", l.Total)
+	case l.PerIP > 0 && cc.byIP[ip] >= l.PerIP:
+		return nil, http.StatusTooManyRequests, fmt.Sprintf("too many connections from %s (limit %d)", ip, l.PerIP)
+	case l.PerDevice > 0 && device != "" && cc.byDevice[device] >= l.PerDevice:
+		return nil, http.StatusTooManyRequests, fmt.Sprintf("too many connections to device %s (limit %d)", device, l.PerDevice)
+	}
+	if cc.byIP == nil {
+		cc.byIP = make(map[string]int)
+		cc.byDevice = make(map[string]int)
+	}
+	cc.total++
+	cc.byIP[ip]++
+	if device != "" {
+		cc.byDevice[device]++
+	}
+	return func() {
+		cc.mu.Lock()
+		defer cc.mu.Unlock()
+		cc.total--
+		decrement(cc.byIP, ip)
+		if device != "" {
+			decrement(cc.byDevice, device)
+		}
+	}, 0, ""
+}
+
+func decrement(m map[string]int, key string) {
+	if m[key] <= 1 {
+		delete(m, key)
+		return
+	}
+	m[key]--
+}
+
+// reserveConn applies h.ConnLimits to r before it is upgraded, writing the
+// rejection when a limit is hit. device is the bound device with its route
+// prefix stripped ("" if unbound).
+func (h *Handler) reserveConn(w http.ResponseWriter, r *http.Request, device string) (release func(), ok bool) {
+	ip := h.clientIP(r)
+	device = strings.ToLower(device)
+	release, status, reason := h.Registry().counts.reserve(h.ConnLimits, ip, device)
+	if release == nil {
+		log.Printf("connection rejected ip=%s device=%s reason=%q", ip, device, reason)
+		w.Header().Set("Retry-After", connLimitRetryAfter)
+		http.Error(w, reason, status)
+		return nil, false
+	}
+	return release, true
+}
@@ -0,0 +1,63 @@
+package ws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestConnLimits(t *testing.T) {
+	loopback, _ := ParseTrustedProxies("127.0.0.1, ::1")
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}, ConnLimits: ConnLimits{Total: 3, PerIP: 2, PerDevice: 1}, TrustedProxies: loopback}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+	dial := func(path, ip string) (*websocket.Conn, int) {
+		t.Helper()
+		hdr := http.Header{"X-Forwarded-For": {ip}}
+		c, resp, err := websocket.DefaultDialer.Dial(base+path, hdr)
+		if err != nil {
+			if resp == nil {
+				t.Fatalf("dial %s: %v", path, err)
+			}
+			return nil, resp.StatusCode
+		}
+		t.Cleanup(func() { c.Close() })
+		return c, http.StatusSwitchingProtocols
+	}
+
+	if _, code := dial("/ws/mac:AABBCCDDEEFF/BlizzardRDK", "10.0.0.1"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("first bound connection refused: %d", code)
+	}
+	if _, code := dial("/ws/aa:bb:cc:dd:ee:ff/BlizzardRDK", "10.0.0.2"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected per-device 429, got %d", code)
+	}
+	first, code := dial("/ws", "10.0.0.1")
+	if code != http.StatusSwitchingProtocols {
+		t.Fatalf("second connection from ip refused: %d", code)
+	}
+	if _, code := dial("/ws", "10.0.0.1"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected per-IP 429, got %d", code)
+	}
+	if _, code := dial("/ws", "10.0.0.3"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("third connection refused: %d", code)
+	}
+	if _, code := dial("/ws", "10.0.0.4"); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected total 503, got %d", code)
+	}
+
+	// Closing a connection frees its slots.
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for h.Registry().Len() == 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, code := dial("/ws", "10.0.0.1"); code != http.StatusSwitchingProtocols {
+		t.Fatalf("slot not released after close: %d", code)
+	}
+}
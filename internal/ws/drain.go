@@ -0,0 +1,82 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// shutdownMethod is the notification telling a client the gateway is
+// draining; no new requests are accepted on the connection after it.
+const shutdownMethod = "gateway.shutdown"
+
+const shutdownReason = "gateway shutting down"
+
+// Shutdown drains every open connection. New upgrades are refused with 503;
+// each client receives a gateway.shutdown notification, new requests are
+// rejected with CodeShuttingDown, and once its in-flight requests have been
+// answered the socket is closed with 1001 (going away). Connections still
+// busy when ctx is done have their upstream calls cancelled and are closed
+// immediately; Shutdown then returns ctx.Err().
+func (h *Handler) Shutdown(ctx context.Context) error {
+	h.draining.Store(true)
+	var wg sync.WaitGroup
+	for _, c := range h.Registry().snapshot() {
+		wg.Add(1)
+		go func(c *client) {
+			defer wg.Done()
+			c.drain(ctx)
+		}(c)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// begin registers a handler with the in-flight wait group unless the
+// connection is draining. Callers that get true must call c.wg.Done.
+func (c *client) begin() bool {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.wg.Add(1)
+	return true
+}
+
+// drain stops the connection accepting requests, waits (until ctx is done)
+// for in-flight ones, then closes it behind any queued responses.
+func (c *client) drain(ctx context.Context) {
+	c.drainMu.Lock()
+	c.draining = true
+	c.drainMu.Unlock()
+	// The notice and close frame bypass the overflow policy: a full queue
+	// must not drop them or turn the 1001 into an overflow close.
+	notice, _ := json.Marshal(rpc.Notification{JSONRPC: "2.0", Method: shutdownMethod, Params: map[string]any{"reason": shutdownReason}})
+	c.queue.pushControl(frame{data: notice})
+
+	idle := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(idle)
+	}()
+	select {
+	case <-idle:
+		c.queue.pushControl(frame{data: []byte(shutdownReason), close: websocket.CloseGoingAway})
+		select {
+		case <-c.ctx.Done(): // read loop saw the close
+		case <-ctx.Done():
+			c.closeWith(websocket.CloseGoingAway, shutdownReason)
+		}
+	case <-ctx.Done():
+		c.cancel()
+		c.closeWith(websocket.CloseGoingAway, shutdownReason)
+	}
+}
+
+func shuttingDownError(req *rpc.Request) *rpc.Response {
+	return &rpc.Response{JSONRPC: "2.0", ID: req.ID, Error: &rpc.Error{Code: rpc.CodeShuttingDown, Message: shutdownReason}}
+}
@@ -0,0 +1,67 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestShutdownDrainsInFlight(t *testing.T) {
+	h := &Handler{Dispatcher: slowDispatcher{delay: 200 * time.Millisecond}}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	u := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+	c, _ := dialURL(t, u)
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Slow.Read"})
+	time.Sleep(50 * time.Millisecond) // let the request reach dispatch
+
+	done := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		done <- h.Shutdown(ctx)
+	}()
+
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var note rpc.Notification
+	if err := c.ReadJSON(&note); err != nil || note.Method != shutdownMethod {
+		t.Fatalf("expected %s notification, got %+v (err=%v)", shutdownMethod, note, err)
+	}
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "Fast.Read"})
+	if resp := readResponse(t, c); string(resp.ID) != "2" || resp.Error == nil || resp.Error.Code != rpc.CodeShuttingDown {
+		t.Fatalf("expected shutting-down error for id 2, got %+v", resp)
+	}
+	if resp := readResponse(t, c); string(resp.ID) != "1" || resp.Error != nil {
+		t.Fatalf("expected in-flight id 1 to complete, got %+v", resp)
+	}
+	_, _, err := c.ReadMessage()
+	var ce *websocket.CloseError
+	if !errors.As(err, &ce) || ce.Code != websocket.CloseGoingAway {
+		t.Fatalf("expected going-away close, got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("shutdown: %v", err)
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(u, nil); err == nil || resp == nil || resp.StatusCode != 503 {
+		t.Fatalf("expected upgrade refused with 503 while draining, got err=%v", err)
+	}
+}
+
+func TestShutdownDeadlineCancels(t *testing.T) {
+	h := &Handler{Dispatcher: slowDispatcher{delay: time.Second}}
+	c := dialTest(t, h)
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Slow.Read"})
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := h.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
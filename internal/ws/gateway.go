@@ -0,0 +1,41 @@
+package ws
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// gatewayMethodPrefix marks methods served by the gateway itself. They are
+// never forwarded to the device.
+const gatewayMethodPrefix = "gateway."
+
+// gatewayMethods maps gateway-local method names to their implementations.
+var gatewayMethods = map[string]func(*client, *rpc.Request) *rpc.Response{
+	"gateway.subscribe":     (*client).subscribe,
+	"gateway.unsubscribe":   (*client).unsubscribe,
+	"gateway.device.status": (*client).deviceStatus,
+}
+
+// gatewayDispatcher serves gateway-local methods for a connection and passes
+// everything else to the connection's upstream dispatcher.
+type gatewayDispatcher struct {
+	c    *client
+	next rpc.Dispatcher
+}
+
+// Handle implements rpc.Dispatcher.
+func (g gatewayDispatcher) Handle(ctx context.Context, r *rpc.Request) *rpc.Response {
+	if r.Method == cancelMethod {
+		g.c.cancelRequest(r)
+		return nil
+	}
+	if !strings.HasPrefix(r.Method, gatewayMethodPrefix) {
+		return g.next.Handle(ctx, r)
+	}
+	if fn, ok := gatewayMethods[r.Method]; ok {
+		return fn(g.c, r)
+	}
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Error: &rpc.Error{Code: -32601, Message: "method not found", Data: r.Method}}
+}
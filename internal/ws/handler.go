@@ -1,18 +1,27 @@
 package ws
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/stepherg/blizzardgw/internal/deviceid"
 	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
 	"github.com/stepherg/blizzardgw/internal/rpc"
 )
 
@@ -20,15 +29,139 @@ import (
 type Handler struct {
 	Upgrader    websocket.Upgrader
 	Dispatcher  rpc.Dispatcher // base dispatcher (used when path has no device/service)
-	SendBufSize int
-	Bus         *events.Bus // optional event bus; if nil notifications only synthetic
+	SendBufSize int            // per-connection outbound queue length (default 64)
+	Bus         *events.Bus    // optional event bus; if nil notifications only synthetic
+	MaxInFlight int            // per-connection concurrent request limit (default 32)
+
+	// OverflowPolicy applies when a connection's send queue is full;
+	// OverflowCloseCode (1013 or 4000-4999, default 1013; see
+	// ParseOverflowCloseCode) is used by Disconnect and when a response
+	// cannot be queued.
+	OverflowPolicy    OverflowPolicy
+	OverflowCloseCode int
+
+	// SessionTTL is how long a disconnected session (and its event buffer)
+	// is kept for resumption; 0 ends sessions with their connection.
+	// SessionBuffer is the number of events retained per session (default 256).
+	// At most MaxDetachedSessions disconnected sessions are kept (default
+	// 1024), MaxDetachedPerIP per client IP (default 16); beyond that the
+	// oldest is ended early.
+	SessionTTL          time.Duration
+	SessionBuffer       int
+	MaxDetachedSessions int
+	MaxDetachedPerIP    int
+
+	// Routes resolves a device's WRP destination prefix, canonical service and
+	// fallbacks. Nil uses EnvRoutes (DEST_PREFIX, CANONICAL_SERVICE_NAME,
+	// DEST_SERVICE_FALLBACKS).
+	Routes RouteResolver
+
+	// ConnLimits caps concurrent connections in total, per client IP and per
+	// bound device; upgrades over a limit are refused with 503/429.
+	ConnLimits ConnLimits
+
+	// TrustedProxies are the peers whose X-Forwarded-For is believed when
+	// identifying the client for connection limits, rate limits and the
+	// registry; from any other peer the header is ignored.
+	TrustedProxies []netip.Prefix
+
+	// Token-bucket request limits per connection, per principal (shared by
+	// all of its connections) and per target device. Zero values disable them.
+	ConnRate      RateLimit
+	PrincipalRate RateLimit
+	DeviceRate    RateLimit
+
+	// ReverseTimeout bounds how long a device-initiated request waits for the
+	// client's response (default 60s); see ReverseHandler.
+	ReverseTimeout time.Duration
+
+	// Timeouts bounds each upstream call by method, device and service, and
+	// caps client-requested deadlines; nil applies 8s to everything.
+	Timeouts *TimeoutPolicy
+
+	// Latency, when set, derives the timeout of calls no Timeouts rule covers
+	// from the observed p99 latency of the device and method. The upstream
+	// dispatcher should record into the same tracker.
+	Latency *rpc.LatencyTracker
+
+	// Middleware wraps each connection's upstream dispatcher, the first entry
+	// outermost. Gateway-local methods (gateway.*) are served before it.
+	Middleware []rpc.Middleware
+
+	// Presence is the device presence table answering gateway.device.status;
+	// nil reports every device as unknown.
+	Presence *presence.Table
+
+	storeOnce sync.Once
+	store     *sessionStore
+
+	regOnce  sync.Once
+	registry *Registry
+
+	routesOnce sync.Once
+
+	limitOnce sync.Once
+	limits    *rateLimiters
+
+	draining atomic.Bool // set by Shutdown; new upgrades are refused
 }
 
 type client struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	id    string
+	conn  *websocket.Conn
+	codec frameCodec // negotiated subprotocol framing
+	sess  *session   // event stream; outlives the connection when resumable
+
+	// In-flight request accounting: sem bounds concurrent dispatches, wg lets
+	// run wait for outstanding handlers before the connection is closed.
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	// ctx is cancelled when the connection closes, aborting every pending
+	// upstream call; pending holds per-request cancels for $/cancelRequest.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending pendingCalls
+
+	// presence answers gateway.device.status.
+	presence *presence.Table
+
+	// calls holds reverse requests sent to the client awaiting its response.
+	calls reverseCalls
+
+	// Request rate limits: the connection's own bucket plus the handler's
+	// shared principal and device buckets.
+	rate      connLimiter
+	limits    *rateLimiters
+	principal string
+
+	// draining stops new requests from being dispatched once Shutdown has
+	// started; drainMu orders it against wg.Add so Shutdown can Wait safely.
+	drainMu  sync.Mutex
+	draining bool
+
+	// queue is drained by writeLoop, the connection's single writer, which
+	// stops once done is closed.
+	queue     *sendQueue
+	done      chan struct{}
+	closeCode int
+	closeOnce sync.Once
+
+	// Registry metadata, fixed at upgrade time except eventsDelivered.
+	remoteAddr      string
+	device          string
+	service         string
+	dispatcherType  string
+	connectedAt     time.Time
+	eventsDelivered atomic.Uint64
 }
 
+// Defaults used when the corresponding Handler fields are unset.
+const (
+	defaultMaxInFlight = 32
+	defaultSendBufSize = 64
+)
+
 // Tunable timing constants (aligned with gorilla/websocket chat example pattern)
 const (
 	pongWait   = 75 * time.Second
@@ -37,70 +170,184 @@ const (
 )
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c, err := h.Upgrader.Upgrade(w, r, nil)
+	if h.draining.Load() {
+		http.Error(w, shutdownReason, http.StatusServiceUnavailable)
+		return
+	}
+	// Device/service binding from the path (/ws/<device>/<service>), query
+	// parameters or headers; unbound connections multiplex.
+	b, err := parseBinding(r)
 	if err != nil {
+		http.Error(w, "invalid device binding: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	device, service := b.device, b.service
+	bound := device != ""
+	route := h.routes().Resolve(device, service)
+	if bound {
+		if _, err := deviceid.Parse(device, deviceid.SchemeOf(route.Prefix)); err != nil {
+			http.Error(w, fmt.Sprintf("invalid device binding: malformed device id %q: %v", device, err), http.StatusBadRequest)
+			return
+		}
+	}
+	release, ok := h.reserveConn(w, r, normalizeDevice(device, route.Prefix))
+	if !ok {
+		return
+	}
+	up := h.Upgrader
+	if len(up.Subprotocols) == 0 {
+		up.Subprotocols = Subprotocols
+	}
+	c, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		release()
 		log.Printf("upgrade failed: %v", err)
 		return
 	}
-	// Derive device/service from path: /ws/<device>/<service>
-	// Trim leading / and split
-	path := r.URL.Path
-	// Expect base path starts with /ws
-	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
-	var dispatcher rpc.Dispatcher = h.Dispatcher
-	if len(segs) >= 3 && segs[0] == "ws" { // ws, device, service
-		device := segs[1]
-		// If the incoming path already includes a mac: style prefix and DEST_PREFIX will add another,
-		// strip the existing one to avoid duplication like mac:mac:<id>/service.
-		// We only handle the simple case where the prefix matches exactly (case-sensitive) and contains ':'.
-		service := segs[2]
-		// If base dispatcher is a *rpc.WRPDispatcher clone with device destination
-		if base, ok := h.Dispatcher.(*rpc.WRPDispatcher); ok {
-			dcopy := *base // shallow copy safe (contains pointers we reuse intentionally: Client)
-			prefix := os.Getenv("DEST_PREFIX")
-			if prefix == "" {
-				prefix = "mac:" // default
-			}
-			if strings.HasPrefix(device, prefix) {
-				orig := device
-				device = strings.TrimPrefix(device, prefix)
-				log.Printf("normalized device prefix: original=%s normalized=%s prefix=%s", orig, device, prefix)
-			}
-			// Canonical service name that the device actually registered with Parodus (default BlizzardRDK)
-			canonical := os.Getenv("CANONICAL_SERVICE_NAME")
-			if canonical == "" {
-				canonical = "BlizzardRDK"
-			}
-			// Destination must always use canonical; the path-provided service may be an alias.
-			dcopy.Dest = prefix + device + "/" + canonical
-			dcopy.ServiceName = canonical
-			log.Printf("connection bound device=%s pathService=%s canonicalService=%s dest=%s", device, service, canonical, dcopy.Dest)
-			dispatcher = &dcopy
-
-			// Fallback services support: DEST_SERVICE_FALLBACKS=svc1,svc2
-			if fb := os.Getenv("DEST_SERVICE_FALLBACKS"); fb != "" {
-				// Build service list: canonical first, then alias (if different), then fallbacks
-				parts := []string{canonical}
-				if service != "" && service != canonical {
-					parts = append(parts, service)
-				}
-				for _, p := range strings.Split(fb, ",") {
-					p = strings.TrimSpace(p)
-					if p != "" && p != canonical && p != service {
-						parts = append(parts, p)
-					}
-				}
-				dispatcher = &rpc.MultiServiceDispatcher{Client: dcopy.Client, Source: dcopy.Source, DeviceID: device, DestPrefix: prefix, Services: parts}
-				log.Printf("multi-service fallback enabled device=%s services=%v (canonical=%s)", device, parts, canonical)
+	var dispatcher *muxDispatcher
+	scope := eventScope{prefix: route.Prefix, all: r.URL.Query().Get("events") == "all"}
+	if bound {
+		// Bound connections only ever receive their own device's events.
+		scope.device = normalizeDevice(device, scope.prefix)
+		scope.all = false
+		dispatcher = &muxDispatcher{h: h, base: h.deviceDispatcher(device, service), bound: true, device: device, service: service}
+	} else {
+		// Unbound connections multiplex: each request may name its own target.
+		dispatcher = &muxDispatcher{h: h, base: h.Dispatcher}
+	}
+	limit := h.MaxInFlight
+	if limit <= 0 {
+		limit = defaultMaxInFlight
+	}
+	bufSize := h.SendBufSize
+	if bufSize <= 0 {
+		bufSize = defaultSendBufSize
+	}
+	closeCode := h.OverflowCloseCode
+	if !validOverflowCloseCode(closeCode) {
+		closeCode = websocket.CloseTryAgainLater
+	}
+	cl := &client{id: uuid.NewString(), conn: c, codec: codecFor(c.Subprotocol()), sem: make(chan struct{}, limit), queue: newSendQueue(bufSize, h.OverflowPolicy), closeCode: closeCode}
+	cl.rate.limit, cl.limits, cl.principal = h.ConnRate, h.limiters(), h.principalOf(r)
+	cl.remoteAddr = h.remoteAddr(r)
+	cl.device, cl.service = device, service
+	// Every request context carries the peer so middleware can see who calls.
+	peer := rpc.Peer{ConnID: cl.id, RemoteAddr: cl.remoteAddr, Principal: cl.principal, Service: service, Authorization: r.Header.Get("Authorization")}
+	if bound {
+		peer.Device = deviceid.Normalize(device, deviceid.SchemeOf(route.Prefix))
+	}
+	cl.ctx, cl.cancel = context.WithCancel(rpc.WithPeer(context.Background(), peer))
+	cl.dispatcherType = fmt.Sprintf("%T", dispatcher.base)
+	cl.connectedAt = time.Now()
+	cl.presence = h.Presence
+
+	// Attach to a new or resumed session; its token (and resume outcome) is
+	// the first frame, followed by any replayed events. The writer sends
+	// these itself before draining the queue, so a replay longer than the
+	// queue is neither subject to the overflow policy nor overtaken by live
+	// events published meanwhile.
+	q := r.URL.Query()
+	since, _ := strconv.ParseUint(q.Get("since"), 10, 64)
+	sess, resumed := h.sessions().open(q.Get("session"), scope, h.clientIP(r))
+	cl.sess = sess
+	last, gap, replay := sess.attach(cl, since)
+	hello, _ := json.Marshal(rpc.Notification{JSONRPC: "2.0", Method: sessionMethod, Params: map[string]any{"session": sess.id, "seq": last, "resumed": resumed, "gap": resumed && gap}})
+	preamble := []frame{{data: hello}}
+	for _, data := range replay {
+		preamble = append(preamble, frame{data: data, event: true})
+	}
+	cl.done = make(chan struct{})
+	go cl.writeLoop(cl.done, preamble)
+	reg := h.Registry()
+	reg.add(cl)
+	go func() {
+		defer reg.remove(cl)
+		defer release()
+		cl.run(rpc.Chain(dispatcher, h.Middleware...))
+	}()
+	if h.draining.Load() { // Shutdown began during the upgrade and may have missed us
+		cl.closeWith(websocket.CloseGoingAway, shutdownReason)
+	}
+}
+
+// deviceDispatcher builds the dispatcher routing to device/service using the
+// handler's route resolver. Only a *rpc.WRPDispatcher base carries a
+// destination; other bases are returned as-is.
+func (h *Handler) deviceDispatcher(device, service string) rpc.Dispatcher {
+	base, ok := h.Dispatcher.(*rpc.WRPDispatcher)
+	if !ok {
+		return h.Dispatcher
+	}
+	route := h.routes().Resolve(device, service)
+	dcopy := *base // shallow copy safe (contains pointers we reuse intentionally: Client)
+	// Canonicalize the device so every spelling of an id (separators, case,
+	// with or without the scheme) yields the same destination. Values that do
+	// not parse only have an exact duplicate prefix stripped.
+	prefix := route.Prefix
+	orig := device
+	if id, err := deviceid.Parse(device, deviceid.SchemeOf(prefix)); err == nil {
+		prefix, device = id.Scheme+":", id.Value
+	} else {
+		device = strings.TrimPrefix(device, prefix)
+		log.Printf("device id not canonicalized: device=%s err=%v", orig, err)
+	}
+	if device != orig && prefix+device != orig {
+		log.Printf("normalized device: original=%s normalized=%s%s", orig, prefix, device)
+	}
+	// Destination must always use the canonical service the device registered
+	// with Parodus; the path-provided service may be an alias.
+	canonical := route.Service
+	dcopy.Dest = prefix + device + "/" + canonical
+	dcopy.ServiceName = canonical
+	log.Printf("route bound device=%s pathService=%s canonicalService=%s dest=%s", device, service, canonical, dcopy.Dest)
+
+	if len(route.Fallbacks) > 0 {
+		// Build service list: canonical first, then alias (if different), then fallbacks
+		parts := []string{canonical}
+		if service != "" && service != canonical {
+			parts = append(parts, service)
+		}
+		for _, p := range route.Fallbacks {
+			if p != canonical && p != service {
+				parts = append(parts, p)
 			}
 		}
+		log.Printf("multi-service fallback enabled device=%s services=%v (canonical=%s)", device, parts, canonical)
+		return &rpc.MultiServiceDispatcher{Client: dcopy.Client, Source: dcopy.Source, DeviceID: device, DestPrefix: prefix, Services: parts, Gate: dcopy.Gate, Latency: dcopy.Latency, Retry: dcopy.Retry}
 	}
-	cl := &client{conn: c}
-	go cl.run(dispatcher, h.Bus)
+	return &dcopy
+}
+
+// routes returns the handler's route resolver, defaulting to EnvRoutes.
+func (h *Handler) routes() RouteResolver {
+	h.routesOnce.Do(func() {
+		if h.Routes == nil {
+			h.Routes = EnvRoutes()
+		}
+	})
+	return h.Routes
+}
+
+// sessions returns the handler's session store, created on first use.
+func (h *Handler) sessions() *sessionStore {
+	h.storeOnce.Do(func() {
+		h.store = newSessionStore(h.Bus, h.SessionTTL, h.SessionBuffer, h.MaxDetachedSessions, h.MaxDetachedPerIP)
+	})
+	return h.store
+}
+
+// Registry returns the handler's live connection registry, created on first use.
+func (h *Handler) Registry() *Registry {
+	h.regOnce.Do(func() {
+		h.registry = newRegistry()
+	})
+	return h.registry
 }
 
-func (c *client) run(d rpc.Dispatcher, bus *events.Bus) {
+func (c *client) run(d rpc.Dispatcher) {
 	defer c.conn.Close()
+	defer c.cancel()
+	defer c.sess.detach(c)
 	// Reader setup
 	c.conn.SetReadLimit(512 * 1024)
 	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
@@ -109,80 +356,145 @@ func (c *client) run(d rpc.Dispatcher, bus *events.Bus) {
 		return nil
 	})
 
-	// Subscribe to events (if bus provided)
-	var evCh <-chan events.Event
-	var cancel func()
-	if bus != nil {
-		_, ch, cfn := bus.Subscribe(64)
-		evCh = ch
-		cancel = cfn
-	}
-	defer func() {
-		if cancel != nil {
-			cancel()
-		}
-	}()
-
-	// Ping loop (keepalive)
-	done := make(chan struct{})
-	go func() {
-		ticker := time.NewTicker(pingPeriod)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				c.mu.Lock()
-				_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-				if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-					c.mu.Unlock()
-					return
-				}
-				c.mu.Unlock()
-			case <-done:
-				return
-			}
-		}
-	}()
-
-	// Event forwarder loop
-	go func() {
-		for {
-			select {
-			case ev, ok := <-evCh:
-				if !ok {
-					return
-				}
-				// Send the inner JSON-RPC payload directly to the client
-				// The payload should already be a valid JSON-RPC message from the device
-				c.writeRaw(ev.Payload)
-			case <-done:
-				return
-			}
-		}
-	}()
+	// Gateway-local methods (gateway.*) are served here, never forwarded.
+	d = gatewayDispatcher{c: c, next: d}
 
 	// Read loop
 	for {
 		mt, message, err := c.conn.ReadMessage()
 		if err != nil {
-			close(done)
+			// Abort pending upstream calls, then let handlers unwind before the deferred Close.
+			c.cancel()
+			c.wg.Wait()
+			close(c.done)
+			if n := c.queue.droppedFrames(); n > 0 {
+				log.Printf("connection closed dropped_frames=%d", n)
+			}
 			return
 		}
 		if mt != websocket.TextMessage && mt != websocket.BinaryMessage {
 			continue
 		}
+		if message, err = c.codec.decode(mt, message); err != nil {
+			c.writeError(nil, -32700, "parse error")
+			continue
+		}
+		if rpc.IsBatch(message) {
+			c.handleBatch(d, message)
+			continue
+		}
+		if resp, ok := rpc.ParseResponse(message); ok {
+			c.calls.complete(resp)
+			continue
+		}
 		req, perr := rpc.ParseRequest(message)
 		if perr != nil {
 			c.writeError(nil, -32600, perr.Error())
 			continue
 		}
-		resp := d.Handle(req)
-		if resp != nil {
-			c.writeJSON(resp)
+		// Every request counts against the rate limits, cancellations and
+		// gateway-local methods included, before it may take an in-flight slot.
+		if resp := c.allow(req); resp != nil {
+			c.reply(req, resp)
+			continue
+		}
+		if req.Method == cancelMethod { // never subject to the in-flight limit
+			c.cancelRequest(req)
+			continue
+		}
+		// Dispatch concurrently so one slow device call does not block later
+		// requests; responses are written as they complete, matched by id.
+		if !c.begin() {
+			c.reply(req, shuttingDownError(req))
+			continue
 		}
-		if gatewayAckEnabled() { // synthetic gateway ack (optional)
-			c.writeJSON(rpc.Notification{JSONRPC: "2.0", Method: "Gateway.Ack", Params: map[string]any{"correlationId": string(req.ID), "id": uuid.NewString()}})
+		if !c.acquire() {
+			c.wg.Done()
+			c.reply(req, c.inFlightError(req))
+			continue
 		}
+		ctx, untrack := c.track(req)
+		go func(req *rpc.Request) {
+			defer func() {
+				untrack()
+				c.release()
+				c.wg.Done()
+			}()
+			c.handle(ctx, d, req)
+		}(req)
+	}
+}
+
+// acquire reserves an in-flight slot without blocking.
+func (c *client) acquire() bool {
+	select {
+	case c.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) release() { <-c.sem }
+
+func (c *client) inFlightError(req *rpc.Request) *rpc.Response {
+	return &rpc.Response{JSONRPC: "2.0", ID: req.ID, Error: &rpc.Error{Code: rpc.CodeTooManyInFlight, Message: "too many in-flight requests", Data: map[string]any{"limit": cap(c.sem)}}}
+}
+
+// handleBatch dispatches a JSON-RPC batch. The batch takes a single
+// in-flight slot and runs at most MaxInFlight of its items at a time; the
+// combined response array is written once every item completes.
+func (c *client) handleBatch(d rpc.Dispatcher, raw []byte) {
+	items, err := rpc.ParseBatch(raw)
+	if err != nil {
+		if errors.Is(err, rpc.ErrEmptyBatch) {
+			c.writeError(nil, -32600, err.Error())
+		} else {
+			c.writeError(nil, -32700, "parse error")
+		}
+		return
+	}
+	if !c.begin() {
+		if resps := rpc.HandleBatch(items, shuttingDownError); len(resps) > 0 {
+			c.writeJSON(resps)
+		}
+		return
+	}
+	if !c.acquire() {
+		c.wg.Done()
+		if resps := rpc.HandleBatch(items, c.inFlightError); len(resps) > 0 {
+			c.writeJSON(resps)
+		}
+		return
+	}
+	go func() {
+		defer func() {
+			c.release()
+			c.wg.Done()
+		}()
+		resps := rpc.HandleBatchLimit(items, cap(c.sem), func(req *rpc.Request) *rpc.Response {
+			if resp := c.allow(req); resp != nil {
+				return resp
+			}
+			ctx, untrack := c.track(req)
+			defer untrack()
+			return c.call(ctx, d, req)
+		})
+		if len(resps) > 0 { // all-notification batches get no reply
+			c.writeJSON(resps)
+		}
+	}()
+}
+
+// handle dispatches a single request and writes its response.
+func (c *client) handle(ctx context.Context, d rpc.Dispatcher, req *rpc.Request) {
+	resp := c.call(ctx, d, req)
+	if c.ctx.Err() != nil { // connection gone; nobody to answer
+		return
+	}
+	c.reply(req, resp)
+	if gatewayAckEnabled() { // synthetic gateway ack (optional)
+		c.writeJSON(rpc.Notification{JSONRPC: "2.0", Method: "Gateway.Ack", Params: map[string]any{"correlationId": string(req.ID), "id": uuid.NewString()}})
 	}
 }
 
@@ -213,47 +525,152 @@ func buildEventMethod(ev events.Event) string {
 	return "rpc.Event." + service + "." + name
 }
 
+// reply writes resp to req unless req is a notification: JSON-RPC 2.0
+// forbids answering those, even with an error.
+func (c *client) reply(req *rpc.Request, resp *rpc.Response) {
+	if resp == nil || req.IsNotification() {
+		return
+	}
+	c.writeJSON(resp)
+}
+
 func (c *client) writeError(id []byte, code int, msg string) {
 	resp := rpc.Response{JSONRPC: "2.0", ID: id, Error: &rpc.Error{Code: code, Message: msg}}
 	c.writeJSON(resp)
 }
 
+// writeJSON queues v as a response-priority frame.
 func (c *client) writeJSON(v interface{}) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	// Refresh per-message write deadline to avoid stale timeout from prior ping when queue backs up.
-	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := c.conn.WriteJSON(v); err != nil {
-		// Provide more diagnostic context for timeouts vs other errors.
-		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
-			log.Printf("write timeout (deadline exceeded) err=%v", err)
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("marshal outbound message failed: %v", err)
+		return
+	}
+	c.enqueue(frame{data: data})
+}
+
+// writeEvent queues an already-encoded event frame.
+func (c *client) writeEvent(data []byte) {
+	c.enqueue(frame{data: data, event: true})
+}
+
+func (c *client) enqueue(f frame) {
+	if !c.queue.push(f) {
+		c.overflowClose()
+	}
+}
+
+// overflowClose disconnects a slow consumer, under the Disconnect policy or
+// when a response could not be queued. Frames dropped after the first
+// overflow do not log again.
+func (c *client) overflowClose() {
+	c.closeOnce.Do(func() {
+		log.Printf("send queue overflow; closing connection code=%d dropped_frames=%d", c.closeCode, c.queue.droppedFrames())
+		c.sendClose(c.closeCode, "send queue overflow")
+	})
+}
+
+// closeWith sends a close frame carrying code and reason, then closes the
+// socket so the read loop unwinds. Only the first close has any effect.
+func (c *client) closeWith(code int, reason string) {
+	c.closeOnce.Do(func() { c.sendClose(code, reason) })
+}
+
+func (c *client) sendClose(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, truncateReason(reason))
+	_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+	_ = c.conn.Close()
+}
+
+// maxCloseReason is the longest close reason that fits a control frame's
+// 125-byte payload after the 2-byte status code.
+const maxCloseReason = 123
+
+// truncateReason shortens reason to maxCloseReason bytes without splitting
+// a UTF-8 sequence; a longer one would make WriteControl fail.
+func truncateReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	n := maxCloseReason
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// writeLoop is the connection's only data writer: it sends preamble, then
+// drains the send queue (responses ahead of events) and sends keepalive
+// pings. A failed write closes the connection so the read loop unwinds.
+func (c *client) writeLoop(done <-chan struct{}, preamble []frame) {
+	for _, f := range preamble {
+		if !c.send(f) {
 			return
 		}
-		// Unwrap if wrapped by websocket library
-		if errors.Is(err, os.ErrDeadlineExceeded) {
-			log.Printf("write deadline exceeded err=%v", err)
+	}
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.queue.ready:
+			for {
+				f, ok := c.queue.pop()
+				if !ok {
+					break
+				}
+				if f.close != 0 { // everything queued ahead of it has been sent
+					c.closeWith(f.close, string(f.data))
+					return
+				}
+				if !c.send(f) {
+					return
+				}
+			}
+		case <-ticker.C:
+			if err := c.writeFrame(websocket.PingMessage, nil); err != nil {
+				_ = c.conn.Close()
+				return
+			}
+		case <-done:
 			return
 		}
-		log.Printf("write error: %T %v", err, err)
 	}
 }
 
-func (c *client) writeRaw(data []byte) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// send writes one data frame; false means the connection failed and has
+// been closed.
+func (c *client) send(f frame) bool {
+	mt, data, err := c.codec.encode(f.data)
+	if err != nil {
+		log.Printf("encode outbound frame failed: %v", err)
+		return true
+	}
+	if err := c.writeFrame(mt, data); err != nil {
+		_ = c.conn.Close()
+		return false
+	}
+	if f.event {
+		c.eventsDelivered.Add(1)
+	}
+	return true
+}
+
+func (c *client) writeFrame(messageType int, data []byte) error {
 	// Refresh per-message write deadline
 	_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	err := c.conn.WriteMessage(messageType, data)
+	if err != nil {
 		// Provide more diagnostic context for timeouts vs other errors.
 		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
 			log.Printf("write timeout (deadline exceeded) err=%v", err)
-			return
+			return err
 		}
 		// Unwrap if wrapped by websocket library
 		if errors.Is(err, os.ErrDeadlineExceeded) {
 			log.Printf("write deadline exceeded err=%v", err)
-			return
+			return err
 		}
 		log.Printf("write error: %T %v", err, err)
 	}
+	return err
 }
@@ -1,9 +1,12 @@
 package ws
 
 import (
+	"context"
 	"encoding/json"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,6 +15,7 @@ import (
 )
 
 func TestWebSocketEcho(t *testing.T) {
+	t.Setenv("GATEWAY_ACK", "1") // ack notification is off by default
 	h := &Handler{Dispatcher: rpc.EchoDispatcher{}}
 	srv := httptest.NewServer(h)
 	defer srv.Close()
@@ -51,7 +55,7 @@ func TestWebSocketEcho(t *testing.T) {
 		}
 		if _, ok := base["id"]; ok {
 			gotResp = true
-		} else if _, ok := base["method"]; ok {
+		} else if string(base["method"]) == `"Gateway.Ack"` {
 			gotNote = true
 		}
 	}
@@ -62,3 +66,131 @@ func TestWebSocketEcho(t *testing.T) {
 		t.Fatalf("did not receive notification")
 	}
 }
+
+// slowDispatcher sleeps for requests whose method starts with "Slow".
+type slowDispatcher struct{ delay time.Duration }
+
+func (s slowDispatcher) Handle(_ context.Context, r *rpc.Request) *rpc.Response {
+	if strings.HasPrefix(r.Method, "Slow") {
+		time.Sleep(s.delay)
+	}
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: r.Method}
+}
+
+func dialTest(t *testing.T, h *Handler) *websocket.Conn {
+	t.Helper()
+	c, _ := dialPath(t, h, "/ws")
+	return c
+}
+
+// dialPath connects to h at path (which may carry a query) and consumes the
+// leading gateway.session notification, returning its params.
+func dialPath(t *testing.T, h *Handler, path string) (*websocket.Conn, map[string]any) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return dialURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+path)
+}
+
+func dialURL(t *testing.T, u string) (*websocket.Conn, map[string]any) {
+	t.Helper()
+	c, _, err := websocket.DefaultDialer.Dial(u, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var note struct {
+		Method string         `json:"method"`
+		Params map[string]any `json:"params"`
+	}
+	if err := c.ReadJSON(&note); err != nil || note.Method != sessionMethod {
+		t.Fatalf("expected %s notification, got %+v (err=%v)", sessionMethod, note, err)
+	}
+	return c, note.Params
+}
+
+func readResponse(t *testing.T, c *websocket.Conn) rpc.Response {
+	t.Helper()
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resp rpc.Response
+	if err := c.ReadJSON(&resp); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return resp
+}
+
+func TestConcurrentRequestsOutOfOrder(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: slowDispatcher{delay: 300 * time.Millisecond}})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Slow.Read"})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "Fast.Read"})
+
+	first := readResponse(t, c)
+	if string(first.ID) != "2" {
+		t.Fatalf("expected fast response (id 2) first, got id %s", first.ID)
+	}
+	second := readResponse(t, c)
+	if string(second.ID) != "1" {
+		t.Fatalf("expected slow response (id 1) second, got id %s", second.ID)
+	}
+}
+
+func TestInFlightLimit(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: slowDispatcher{delay: 300 * time.Millisecond}, MaxInFlight: 1})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Slow.Read"})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "Slow.Read"})
+
+	rejected := readResponse(t, c)
+	if string(rejected.ID) != "2" || rejected.Error == nil || rejected.Error.Code != rpc.CodeTooManyInFlight {
+		t.Fatalf("expected in-flight error for id 2, got %+v", rejected)
+	}
+	ok := readResponse(t, c)
+	if string(ok.ID) != "1" || ok.Error != nil {
+		t.Fatalf("expected success for id 1, got %+v", ok)
+	}
+}
+
+func TestBatchRequest(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: slowDispatcher{delay: 50 * time.Millisecond}})
+	batch := `[{"jsonrpc":"2.0","id":1,"method":"Slow.A"},{"jsonrpc":"2.0","method":"Note"},{"jsonrpc":"2.0","id":2,"method":"Slow.B"}]`
+	if err := c.WriteMessage(websocket.TextMessage, []byte(batch)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resps []rpc.Response
+	if err := c.ReadJSON(&resps); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(resps) != 2 || string(resps[0].ID) != "1" || string(resps[1].ID) != "2" {
+		t.Fatalf("unexpected batch response: %+v", resps)
+	}
+
+	if err := c.WriteMessage(websocket.TextMessage, []byte(`[]`)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32600 {
+		t.Fatalf("expected invalid request for empty batch, got %+v", resp)
+	}
+}
+
+func TestBatchLargerThanInFlightLimit(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: slowDispatcher{delay: 20 * time.Millisecond}, MaxInFlight: 4})
+	items := make([]map[string]any, 10)
+	for i := range items {
+		items[i] = map[string]any{"jsonrpc": "2.0", "id": i, "method": "Slow.Read"}
+	}
+	_ = c.WriteJSON(items)
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var resps []rpc.Response
+	if err := c.ReadJSON(&resps); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(resps) != len(items) {
+		t.Fatalf("expected %d responses, got %d", len(items), len(resps))
+	}
+	for i, r := range resps {
+		if r.Error != nil || string(r.ID) != strconv.Itoa(i) {
+			t.Fatalf("item %d: unexpected response %+v", i, r)
+		}
+	}
+}
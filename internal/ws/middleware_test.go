@@ -0,0 +1,43 @@
+package ws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestMiddlewareSeesPeer(t *testing.T) {
+	peers := make(chan rpc.Peer, 1)
+	policy := func(next rpc.Dispatcher) rpc.Dispatcher {
+		return rpc.DispatcherFunc(func(ctx context.Context, r *rpc.Request) *rpc.Response {
+			p, _ := rpc.PeerFrom(ctx)
+			peers <- p
+			if r.Method == "Device.Reboot" {
+				return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Error: &rpc.Error{Code: -32001, Message: "forbidden"}}
+			}
+			return next.Handle(ctx, r)
+		})
+	}
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}, Middleware: []rpc.Middleware{rpc.Recover, policy}}
+	c, _ := dialPath(t, h, "/ws/AA:BB:CC:DD:EE:FF/BlizzardRDK")
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Reboot"})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32001 {
+		t.Fatalf("expected policy rejection, got %+v", resp)
+	}
+	if p := <-peers; p.Device != "mac:aabbccddeeff" || p.Service != "BlizzardRDK" || p.ConnID == "" {
+		t.Fatalf("unexpected peer: %+v", p)
+	}
+
+	// Gateway-local methods are served ahead of the chain.
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "gateway.device.status"})
+	if resp := readResponse(t, c); resp.Error != nil {
+		t.Fatalf("unexpected error: %+v", resp)
+	}
+	select {
+	case p := <-peers:
+		t.Fatalf("gateway method passed through middleware: %+v", p)
+	default:
+	}
+}
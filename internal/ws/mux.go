@@ -0,0 +1,151 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// targetParam is the reserved params member naming a request's device and
+// service on a multiplexed (unbound) connection. It is stripped before the
+// request is forwarded.
+const targetParam = "_target"
+
+// maxTargetCache bounds the per-connection cache of target dispatchers; past
+// it an arbitrary entry is dropped (and rebuilt on its next use).
+const maxTargetCache = 64
+
+// target identifies the device/service a multiplexed request is routed to.
+type target struct {
+	Device  string `json:"device"`
+	Service string `json:"service"`
+}
+
+// muxDispatcher routes each request on an unbound connection to the device
+// and service named in its params, building (and caching, by canonical device
+// id) the per-device dispatcher on first use. Requests without a target use the base dispatcher.
+// On a device-bound connection (bound=true) a target is rejected rather than
+// silently routed to the bound device.
+type muxDispatcher struct {
+	h     *Handler
+	base  rpc.Dispatcher
+	bound bool
+
+	// device and service the connection is bound to, for the timeout policy.
+	device, service string
+
+	mu    sync.Mutex
+	cache map[target]rpc.Dispatcher
+}
+
+// Handle implements rpc.Dispatcher. The call is bounded by the handler's
+// timeout policy for its method and device.
+func (m *muxDispatcher) Handle(ctx context.Context, r *rpc.Request) *rpc.Response {
+	t, stripped, err := extractTarget(r)
+	if err != nil {
+		return invalidParams(r, err.Error())
+	}
+	d, device, service := m.base, m.device, m.service
+	if t == nil {
+		if !m.bound && unroutable(m.base) {
+			return invalidParams(r, "connection is not bound to a device; bind it or set "+targetParam)
+		}
+	} else {
+		if m.bound {
+			return invalidParams(r, targetParam+" not allowed on a device-bound connection")
+		}
+		// Validated like a bound connection's device, then canonical, so
+		// spellings of one device share a cache entry.
+		route := m.h.routes().Resolve(t.Device, t.Service)
+		id, err := deviceid.Parse(t.Device, deviceid.SchemeOf(route.Prefix))
+		if err != nil || strings.Contains(t.Device, "/") {
+			return invalidParams(r, fmt.Sprintf("%s.device: malformed device id %q", targetParam, t.Device))
+		}
+		t.Device = id.String()
+		d, device, service = m.dispatcherFor(*t), t.Device, t.Service
+	}
+	ctx, cancel, stripped, err := m.h.withTimeout(ctx, stripped, device, service)
+	if err != nil {
+		return invalidParams(r, err.Error())
+	}
+	defer cancel()
+	return d.Handle(ctx, stripped)
+}
+
+func (m *muxDispatcher) dispatcherFor(t target) rpc.Dispatcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if d, ok := m.cache[t]; ok {
+		return d
+	}
+	if m.cache == nil {
+		m.cache = make(map[target]rpc.Dispatcher)
+	}
+	if len(m.cache) >= maxTargetCache {
+		for k := range m.cache {
+			delete(m.cache, k)
+			break
+		}
+	}
+	d := m.h.deviceDispatcher(t.Device, t.Service)
+	m.cache[t] = d
+	return d
+}
+
+// unroutable reports whether d would send WRP messages with no destination.
+func unroutable(d rpc.Dispatcher) bool {
+	w, ok := d.(*rpc.WRPDispatcher)
+	return ok && w.Dest == ""
+}
+
+// extractTarget returns the request's target (nil when absent) and a copy of
+// the request with the reserved member removed from params.
+func extractTarget(r *rpc.Request) (*target, *rpc.Request, error) {
+	raw, out, err := takeParam(r, targetParam)
+	if err != nil || raw == nil {
+		return nil, out, err
+	}
+	var t target
+	if err := json.Unmarshal(raw, &t); err != nil {
+		return nil, nil, errors.New(targetParam + ": " + err.Error())
+	}
+	t.Device = strings.TrimSpace(t.Device)
+	if t.Device == "" {
+		return nil, nil, errors.New(targetParam + ".device required")
+	}
+	return &t, out, nil
+}
+
+// takeParam removes the reserved member name from r's params, returning its
+// raw value (nil when absent) and a copy of r without it. Non-object params
+// (e.g. positional) carry no reserved members and are returned unchanged.
+func takeParam(r *rpc.Request, name string) (json.RawMessage, *rpc.Request, error) {
+	if len(r.Params) == 0 || !strings.Contains(string(r.Params), name) {
+		return nil, r, nil
+	}
+	var params map[string]json.RawMessage
+	if err := json.Unmarshal(r.Params, &params); err != nil {
+		return nil, r, nil
+	}
+	raw, ok := params[name]
+	if !ok {
+		return nil, r, nil
+	}
+	delete(params, name)
+	out := *r
+	out.Params = nil
+	if len(params) > 0 {
+		b, err := json.Marshal(params)
+		if err != nil {
+			return nil, nil, err
+		}
+		out.Params = b
+	}
+	return raw, &out, nil
+}
@@ -0,0 +1,114 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+// newScytale returns a mock Scytale that records inbound WRP messages and
+// answers with a JSON-RPC result echoing the destination.
+func newScytale(t *testing.T) (*httptest.Server, func() []wrp.Message) {
+	t.Helper()
+	var mu sync.Mutex
+	var seen []wrp.Message
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in wrp.Message
+		if err := wrp.NewDecoder(r.Body, wrp.Msgpack).Decode(&in); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		seen = append(seen, in)
+		mu.Unlock()
+		var req rpc.Request
+		_ = json.Unmarshal(in.Payload, &req)
+		payload, _ := json.Marshal(rpc.Response{JSONRPC: "2.0", ID: req.ID, Result: in.Destination})
+		buf := &bytes.Buffer{}
+		_ = wrp.NewEncoder(buf, wrp.Msgpack).Encode(&wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Payload: payload})
+		w.Header().Set("Content-Type", "application/msgpack")
+		_, _ = w.Write(buf.Bytes())
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []wrp.Message {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]wrp.Message(nil), seen...)
+	}
+}
+
+func TestMultiplexedTargets(t *testing.T) {
+	srv, seen := newScytale(t)
+	c := dialTest(t, &Handler{Dispatcher: &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: srv.URL}, Source: "blizzard/gateway"}})
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Ping", "params": map[string]any{"_target": map[string]any{"device": "112233445566", "service": "BlizzardRDK"}, "x": 1}})
+	if resp := readResponse(t, c); resp.Result != "mac:112233445566/BlizzardRDK" {
+		t.Fatalf("unexpected routing for first target: %+v", resp)
+	}
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "Device.Ping", "params": map[string]any{"_target": map[string]any{"device": "AA-BB-CC-DD-EE-FF"}}})
+	if resp := readResponse(t, c); resp.Result != "mac:aabbccddeeff/BlizzardRDK" {
+		t.Fatalf("unexpected routing for second target: %+v", resp)
+	}
+
+	msgs := seen()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 upstream messages, got %d", len(msgs))
+	}
+	var fwd rpc.Request
+	_ = json.Unmarshal(msgs[0].Payload, &fwd)
+	if string(fwd.Params) != `{"x":1}` {
+		t.Fatalf("reserved member not stripped: %s", fwd.Params)
+	}
+	var second rpc.Request
+	_ = json.Unmarshal(msgs[1].Payload, &second)
+	if len(second.Params) != 0 {
+		t.Fatalf("expected params to be dropped when only the target was present, got %s", second.Params)
+	}
+}
+
+func TestBoundConnectionRejectsTarget(t *testing.T) {
+	c, _ := dialPath(t, &Handler{Dispatcher: rpc.EchoDispatcher{}}, "/ws/112233445566/BlizzardRDK")
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Ping", "params": map[string]any{"_target": map[string]any{"device": "aabbccddeeff"}}})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("expected invalid params, got %+v", resp)
+	}
+}
+
+func TestMultiplexedTargetValidation(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}})
+	for i, device := range []string{"a/b", "mac:not-a-mac", "mac:112233445566/Other"} {
+		_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": i, "method": "Device.Ping", "params": map[string]any{"_target": map[string]any{"device": device}}})
+		if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+			t.Fatalf("%q: expected invalid params, got %+v", device, resp)
+		}
+	}
+}
+
+func TestMultiplexedTargetCache(t *testing.T) {
+	m := &muxDispatcher{h: &Handler{Dispatcher: rpc.EchoDispatcher{}}, base: rpc.EchoDispatcher{}}
+	call := func(device string) {
+		params, _ := json.Marshal(map[string]any{"_target": map[string]any{"device": device}})
+		if resp := m.Handle(context.Background(), &rpc.Request{JSONRPC: "2.0", ID: json.RawMessage(`1`), Method: "Device.Ping", Params: params}); resp.Error != nil {
+			t.Fatalf("%q: %+v", device, resp.Error)
+		}
+	}
+	call("112233445566")
+	call("MAC:11:22:33:44:55:66")
+	if len(m.cache) != 1 {
+		t.Fatalf("spellings of one device cached separately: %d entries", len(m.cache))
+	}
+	for i := 0; i < 2*maxTargetCache; i++ {
+		call(fmt.Sprintf("mac:%012x", i))
+	}
+	if len(m.cache) > maxTargetCache {
+		t.Fatalf("cache grew to %d entries", len(m.cache))
+	}
+}
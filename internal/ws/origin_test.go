@@ -0,0 +1,79 @@
+package ws
+
+import (
+	"expvar"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		spec   string
+		origin string
+		host   string
+		want   bool
+	}{
+		{"no origin header", "https://app.example.com", "", "gw:8920", true},
+		{"allow all", "*", "https://evil.test", "gw:8920", true},
+		{"exact match", "https://app.example.com", "https://app.example.com", "gw:8920", true},
+		{"exact case-insensitive", "https://App.Example.com", "https://app.example.COM", "gw:8920", true},
+		{"exact scheme mismatch", "https://app.example.com", "http://app.example.com", "gw:8920", false},
+		{"exact port mismatch", "https://app.example.com", "https://app.example.com:8443", "gw:8920", false},
+		{"wildcard subdomain", "https://*.example.com", "https://ui.eu.example.com", "gw:8920", true},
+		{"wildcard excludes apex", "https://*.example.com", "https://example.com", "gw:8920", false},
+		{"wildcard lookalike", "*.example.com", "https://badexample.com", "gw:8920", false},
+		{"wildcard any scheme", "*.example.com", "http://ui.example.com", "gw:8920", true},
+		{"same-host default", "", "http://gw:8920", "gw:8920", true},
+		{"same-host rejects other", "", "http://other:8920", "gw:8920", false},
+		{"list with same-host", "https://app.example.com,same-host", "http://gw:8920", "gw:8920", true},
+		{"malformed", "https://app.example.com", "::not-a-url", "gw:8920", false},
+		{"exact trailing slash", "https://app.example.com/", "https://app.example.com", "gw:8920", true},
+		{"wildcard trailing slash", "https://*.example.com/", "https://ui.example.com", "gw:8920", true},
+		{"wildcard with port", "https://*.example.com:8443", "https://ui.example.com:8443", "gw:8920", true},
+		{"wildcard port mismatch", "https://*.example.com:8443", "https://ui.example.com", "gw:8920", false},
+		{"scheme-less wildcard with port", "*.example.com:8443", "http://ui.example.com:8443", "gw:8920", true},
+	}
+	published := func() int64 {
+		v, _ := wsMetrics.Get("origin_rejected").(*expvar.Int)
+		if v == nil {
+			return 0
+		}
+		return v.Value()
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ParseOriginPolicy(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := published()
+			r := httptest.NewRequest("GET", "http://"+tt.host+"/ws", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := p.CheckOrigin(r); got != tt.want {
+				t.Errorf("CheckOrigin(%q) with %q = %v, want %v", tt.origin, tt.spec, got, tt.want)
+			}
+			if !tt.want && (p.Rejected() != 1 || published()-before != 1) {
+				t.Errorf("rejection not counted")
+			}
+		})
+	}
+}
+
+func TestOriginPolicyRejectsInvalidEntries(t *testing.T) {
+	for _, spec := range []string{
+		"app.example.com",
+		"https://app.example.com/path",
+		"https://a.*.example.com",
+		"https://*example.com",
+		"https://*.",
+		"https://app example.com",
+		"https://app.example.com,ftp//x",
+	} {
+		if _, err := ParseOriginPolicy(spec); err == nil {
+			t.Errorf("ParseOriginPolicy(%q) accepted", spec)
+		}
+	}
+}
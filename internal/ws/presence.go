@@ -0,0 +1,44 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/presence"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// deviceStatusResult is the gateway.device.status result; Known is false
+// when no status event or traffic has been seen for the device.
+type deviceStatusResult struct {
+	presence.Status
+	Known bool `json:"known"`
+}
+
+// deviceStatus implements gateway.device.status. params.device defaults to
+// the bound device.
+func (c *client) deviceStatus(r *rpc.Request) *rpc.Response {
+	var p struct {
+		Device string `json:"device"`
+	}
+	if len(r.Params) > 0 {
+		if err := json.Unmarshal(r.Params, &p); err != nil {
+			return invalidParams(r, err.Error())
+		}
+	}
+	device := p.Device
+	if device == "" {
+		device = c.sess.scope.device
+	}
+	if device == "" {
+		return invalidParams(r, "device required on an unbound connection")
+	}
+	device = deviceid.Normalize(device, deviceid.SchemeOf(c.sess.scope.prefix))
+	res := deviceStatusResult{Status: presence.Status{Device: device}}
+	if c.presence != nil {
+		if s, ok := c.presence.Get(device); ok {
+			res = deviceStatusResult{Status: s, Known: true}
+		}
+	}
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: res}
+}
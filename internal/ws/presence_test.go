@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestDevicePresence(t *testing.T) {
+	bus := events.NewBus()
+	tbl := presence.NewTable()
+	tbl.Bus = bus
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}, Bus: bus, Presence: tbl}
+	c, _ := dialPath(t, h, "/ws/mac:112233445566/BlizzardRDK")
+
+	// Subscriptions do not filter presence out for the bound device.
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "gateway.subscribe", "params": map[string]any{"event": "Time.*"}})
+	readResponse(t, c)
+	tbl.Update(presence.Status{Device: "mac:aabbccddeeff", LastSeen: time.Now()})
+	tbl.Update(presence.Status{Device: "mac:112233445566", LastSeen: time.Now(), Reason: "ping miss"})
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var note struct {
+		Method string          `json:"method"`
+		Params presence.Status `json:"params"`
+	}
+	if err := c.ReadJSON(&note); err != nil || note.Method != presence.DeviceOffline || note.Params.Reason != "ping miss" {
+		t.Fatalf("expected offline notification for the bound device, got %+v (err=%v)", note, err)
+	}
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "gateway.device.status"})
+	if m, _ := readResponse(t, c).Result.(map[string]any); m["known"] != true || m["device"] != "mac:112233445566" || m["online"] != false || m["reason"] != "ping miss" {
+		t.Fatalf("unexpected status: %v", m)
+	}
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": "u", "method": "gateway.device.status", "params": map[string]any{"device": "001122334455"}})
+	if m, _ := readResponse(t, c).Result.(map[string]any); m["known"] != false || m["device"] != "mac:001122334455" {
+		t.Fatalf("expected unknown device, got %v", m)
+	}
+}
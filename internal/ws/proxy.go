@@ -0,0 +1,83 @@
+package ws
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of proxy addresses and
+// CIDR ranges ("10.0.0.0/8, 192.0.2.7") whose X-Forwarded-For is believed.
+func ParseTrustedProxies(spec string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(entry); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		out = append(out, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return out, nil
+}
+
+// trustedProxy reports whether ip (without port) is in h.TrustedProxies.
+func (h *Handler) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range h.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteAddr returns the client address. X-Forwarded-For is only honoured
+// when the immediate peer is a trusted proxy; the client is then the
+// right-most hop not itself a trusted proxy (hops further left are
+// client-supplied and could be forged).
+func (h *Handler) remoteAddr(r *http.Request) string {
+	peer := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		peer = host
+	}
+	xff := r.Header.Values("X-Forwarded-For")
+	if len(xff) == 0 || !h.trustedProxy(peer) {
+		return r.RemoteAddr
+	}
+	hops := strings.Split(strings.Join(xff, ","), ",")
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		client = hop
+		if !h.trustedProxy(hop) {
+			break
+		}
+	}
+	return client
+}
+
+// clientIP is remoteAddr without the port.
+func (h *Handler) clientIP(r *http.Request) string {
+	addr := h.remoteAddr(r)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
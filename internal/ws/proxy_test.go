@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteAddrTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &Handler{TrustedProxies: proxies}
+	tests := []struct {
+		name, peer, xff, want string
+	}{
+		{"no header", "203.0.113.5:4000", "", "203.0.113.5:4000"},
+		{"untrusted peer ignores header", "203.0.113.5:4000", "198.51.100.1", "203.0.113.5:4000"},
+		{"trusted peer", "192.0.2.7:4000", "198.51.100.1", "198.51.100.1"},
+		{"forged left-most hop", "192.0.2.7:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},
+		{"proxy chain", "10.1.1.1:4000", "1.2.3.4, 198.51.100.1, 10.2.2.2", "198.51.100.1"},
+		{"all hops trusted", "10.1.1.1:4000", "10.3.3.3, 10.2.2.2", "10.3.3.3"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.RemoteAddr = tt.peer
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := h.remoteAddr(r); got != tt.want {
+			t.Errorf("%s: remoteAddr = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := ParseTrustedProxies("10.0.0.0/8, nope"); err == nil {
+		t.Error("expected error for an invalid entry")
+	}
+}
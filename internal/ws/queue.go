@@ -0,0 +1,169 @@
+package ws
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// OverflowPolicy selects what happens when a connection's send queue is full.
+// Responses are never dropped under any policy: a response that cannot be
+// queued, even by evicting an event, closes the connection as Disconnect
+// does.
+type OverflowPolicy int
+
+const (
+	// DropOldest evicts the oldest queued event to make room for the new
+	// frame. When only responses are queued a new event is discarded.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the event being queued; a response instead evicts
+	// the newest queued event.
+	DropNewest
+	// Disconnect closes the connection with Handler.OverflowCloseCode.
+	Disconnect
+)
+
+// ParseOverflowPolicy parses "drop-oldest", "drop-newest" or "disconnect".
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "drop-oldest":
+		return DropOldest, nil
+	case "drop-newest":
+		return DropNewest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return DropOldest, fmt.Errorf("unknown overflow policy %q", s)
+}
+
+// ParseOverflowCloseCode parses the close code sent by the Disconnect
+// policy: 1013 (try again later) or an application code in 4000-4999.
+// Empty selects 1013.
+func ParseOverflowCloseCode(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return websocket.CloseTryAgainLater, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil || !validOverflowCloseCode(code) {
+		return websocket.CloseTryAgainLater, fmt.Errorf("invalid overflow close code %q (want 1013 or 4000-4999)", s)
+	}
+	return code, nil
+}
+
+func validOverflowCloseCode(code int) bool {
+	return code == websocket.CloseTryAgainLater || (code >= 4000 && code <= 4999)
+}
+
+func (p OverflowPolicy) String() string {
+	switch p {
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "drop-oldest"
+}
+
+// frame is one queued outbound JSON message.
+type frame struct {
+	data  []byte
+	event bool // events yield to responses and are evicted first
+	close int  // when non-zero, a close frame with this code and data as reason
+}
+
+// sendQueue is a bounded per-connection outbound queue drained by a single
+// writer goroutine. Responses are always dequeued ahead of events.
+type sendQueue struct {
+	mu        sync.Mutex
+	responses []frame
+	events    []frame
+	limit     int
+	policy    OverflowPolicy
+	dropped   uint64
+	ready     chan struct{} // signalled (non-blocking) when frames are queued
+}
+
+func newSendQueue(limit int, policy OverflowPolicy) *sendQueue {
+	return &sendQueue{limit: limit, policy: policy, ready: make(chan struct{}, 1)}
+}
+
+// push queues f, applying the overflow policy when full. It returns false
+// when the caller must close the connection: under the Disconnect policy, or
+// when a response finds only responses queued.
+func (q *sendQueue) push(f frame) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.responses)+len(q.events) >= q.limit {
+		q.dropped++
+		switch {
+		case q.policy == Disconnect:
+			return false
+		case f.event && (q.policy == DropNewest || len(q.events) == 0):
+			return true // the new event is discarded
+		case len(q.events) == 0:
+			return false // nothing to evict, and a response is never dropped
+		case q.policy == DropNewest:
+			q.events = q.events[:len(q.events)-1]
+		default:
+			q.events = q.events[1:]
+		}
+	}
+	if f.event {
+		q.events = append(q.events, f)
+	} else {
+		q.responses = append(q.responses, f)
+	}
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// pushControl queues f behind the queued responses regardless of the limit
+// and the overflow policy, for frames that must not be lost (shutdown
+// notice and close).
+func (q *sendQueue) pushControl(f frame) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.responses = append(q.responses, f)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop removes the next frame, responses first.
+func (q *sendQueue) pop() (frame, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.responses) > 0 {
+		f := q.responses[0]
+		q.responses = q.responses[1:]
+		return f, true
+	}
+	if len(q.events) > 0 {
+		f := q.events[0]
+		q.events = q.events[1:]
+		return f, true
+	}
+	return frame{}, false
+}
+
+// depth returns the number of queued frames.
+func (q *sendQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.responses) + len(q.events)
+}
+
+// droppedFrames returns the number of frames discarded by the overflow policy.
+func (q *sendQueue) droppedFrames() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
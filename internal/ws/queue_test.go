@@ -0,0 +1,116 @@
+package ws
+
+import "testing"
+
+func drain(q *sendQueue) []string {
+	var out []string
+	for {
+		f, ok := q.pop()
+		if !ok {
+			return out
+		}
+		out = append(out, string(f.data))
+	}
+}
+
+func TestSendQueueResponsesFirst(t *testing.T) {
+	q := newSendQueue(4, DropOldest)
+	q.push(frame{data: []byte("e1"), event: true})
+	q.push(frame{data: []byte("r1")})
+	q.push(frame{data: []byte("e2"), event: true})
+	q.push(frame{data: []byte("r2")})
+	got := drain(q)
+	want := []string{"r1", "r2", "e1", "e2"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestSendQueueOverflowPolicies(t *testing.T) {
+	q := newSendQueue(2, DropOldest)
+	q.push(frame{data: []byte("e1"), event: true})
+	q.push(frame{data: []byte("r1")})
+	q.push(frame{data: []byte("r2")}) // evicts e1, the oldest event
+	if got := drain(q); len(got) != 2 || got[0] != "r1" || got[1] != "r2" {
+		t.Fatalf("drop-oldest kept %v", got)
+	}
+	if q.droppedFrames() != 1 {
+		t.Fatalf("dropped = %d, want 1", q.droppedFrames())
+	}
+	// Queued responses are never evicted: a new event is dropped instead,
+	// and a new response must close the connection.
+	q.push(frame{data: []byte("r3")})
+	q.push(frame{data: []byte("r4")})
+	if !q.push(frame{data: []byte("e2"), event: true}) {
+		t.Fatal("dropping an event should not close the connection")
+	}
+	if q.push(frame{data: []byte("r5")}) {
+		t.Fatal("a response that cannot be queued must close the connection")
+	}
+	if got := drain(q); len(got) != 2 || got[0] != "r3" || got[1] != "r4" {
+		t.Fatalf("drop-oldest evicted a response: %v", got)
+	}
+
+	q = newSendQueue(2, DropNewest)
+	q.push(frame{data: []byte("e1"), event: true})
+	q.push(frame{data: []byte("e2"), event: true})
+	q.push(frame{data: []byte("e3"), event: true}) // discarded
+	q.push(frame{data: []byte("r1")})              // evicts e2, the newest event
+	if got := drain(q); len(got) != 2 || got[0] != "r1" || got[1] != "e1" {
+		t.Fatalf("drop-newest kept %v", got)
+	}
+	q.push(frame{data: []byte("r1")})
+	q.push(frame{data: []byte("r2")})
+	if q.push(frame{data: []byte("r3")}) {
+		t.Fatal("a response that cannot be queued must close the connection")
+	}
+
+	q = newSendQueue(1, Disconnect)
+	if !q.push(frame{data: []byte("r1")}) {
+		t.Fatalf("first push should succeed")
+	}
+	if q.push(frame{data: []byte("r2")}) {
+		t.Fatalf("overflow under disconnect policy should report failure")
+	}
+	if q.depth() != 1 || q.droppedFrames() != 1 {
+		t.Fatalf("depth=%d dropped=%d", q.depth(), q.droppedFrames())
+	}
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	for in, want := range map[string]OverflowPolicy{"": DropOldest, "drop-oldest": DropOldest, "DROP-NEWEST": DropNewest, "disconnect": Disconnect} {
+		if got, err := ParseOverflowPolicy(in); err != nil || got != want {
+			t.Errorf("ParseOverflowPolicy(%q) = %v, %v", in, got, err)
+		}
+	}
+	if _, err := ParseOverflowPolicy("bogus"); err == nil {
+		t.Errorf("expected error for unknown policy")
+	}
+}
+
+func TestParseOverflowCloseCode(t *testing.T) {
+	for in, want := range map[string]int{"": 1013, "1013": 1013, "4000": 4000, " 4999 ": 4999} {
+		if got, err := ParseOverflowCloseCode(in); err != nil || got != want {
+			t.Errorf("ParseOverflowCloseCode(%q) = %d, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"1000", "1008", "3999", "5000", "x"} {
+		if _, err := ParseOverflowCloseCode(in); err == nil {
+			t.Errorf("expected error for %q", in)
+		}
+	}
+}
+
+func TestSendQueuePushControlBypassesPolicy(t *testing.T) {
+	for _, policy := range []OverflowPolicy{DropOldest, DropNewest, Disconnect} {
+		q := newSendQueue(1, policy)
+		q.push(frame{data: []byte("r1")})
+		q.push(frame{data: []byte("e1"), event: true})
+		q.pushControl(frame{data: []byte("bye"), close: 1001})
+		if got := drain(q); len(got) != 2 || got[0] != "r1" || got[1] != "bye" {
+			t.Fatalf("%s: queue = %v, want [r1 bye]", policy, got)
+		}
+	}
+}
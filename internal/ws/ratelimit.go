@@ -0,0 +1,186 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// RateLimit configures a token bucket: Rate requests per second sustained,
+// with bursts of up to Burst. A zero Rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseRateLimit parses "<rate>[:<burst>]", e.g. "10" or "10:40". The burst
+// defaults to the rate rounded up. An empty spec disables the limit.
+func ParseRateLimit(spec string) (RateLimit, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return RateLimit{}, nil
+	}
+	rs, bs, hasBurst := strings.Cut(spec, ":")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(rs), 64)
+	if err != nil || rate < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", spec)
+	}
+	l := RateLimit{Rate: rate, Burst: int(math.Ceil(rate))}
+	if hasBurst {
+		if l.Burst, err = strconv.Atoi(strings.TrimSpace(bs)); err != nil || l.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid rate limit burst %q", spec)
+		}
+	}
+	return l, nil
+}
+
+func (l RateLimit) enabled() bool { return l.Rate > 0 }
+
+// tokenBucket is a single token bucket; callers serialize access.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take spends one token, refilling for the time elapsed since the last call.
+// When empty it returns false and how long until a token is available.
+func (b *tokenBucket) take(l RateLimit, now time.Time) (bool, time.Duration) {
+	burst := float64(max(l.Burst, 1))
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.Rate)
+	}
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.Rate * float64(time.Second))
+}
+
+// connLimiter is a connection's own bucket (used only from its read loop
+// and batch goroutines, hence the mutex).
+type connLimiter struct {
+	limit RateLimit
+	mu    sync.Mutex
+	b     tokenBucket
+}
+
+func (cl *connLimiter) take(now time.Time) (bool, time.Duration) {
+	if !cl.limit.enabled() {
+		return true, 0
+	}
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	return cl.b.take(cl.limit, now)
+}
+
+// keyedLimiter holds one bucket per key (principal or device), shared by
+// every connection. Buckets that have refilled completely are swept.
+type keyedLimiter struct {
+	limit RateLimit
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+const rateSweepInterval = time.Minute
+
+func newKeyedLimiter(limit RateLimit) *keyedLimiter {
+	return &keyedLimiter{limit: limit, buckets: make(map[string]*tokenBucket)}
+}
+
+func (kl *keyedLimiter) take(key string, now time.Time) (bool, time.Duration) {
+	if kl == nil || !kl.limit.enabled() || key == "" {
+		return true, 0
+	}
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	if now.Sub(kl.swept) > rateSweepInterval {
+		full := time.Duration(float64(max(kl.limit.Burst, 1)) / kl.limit.Rate * float64(time.Second))
+		for k, b := range kl.buckets {
+			if now.Sub(b.last) > full {
+				delete(kl.buckets, k)
+			}
+		}
+		kl.swept = now
+	}
+	b, ok := kl.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		kl.buckets[key] = b
+	}
+	return b.take(kl.limit, now)
+}
+
+// rateLimiters are the handler-wide principal and device limiters.
+type rateLimiters struct {
+	principal *keyedLimiter
+	device    *keyedLimiter
+}
+
+// limiters returns the handler's shared limiters, created on first use.
+func (h *Handler) limiters() *rateLimiters {
+	h.limitOnce.Do(func() {
+		h.limits = &rateLimiters{principal: newKeyedLimiter(h.PrincipalRate), device: newKeyedLimiter(h.DeviceRate)}
+	})
+	return h.limits
+}
+
+// principalOf identifies who opened the connection for rate limiting. Until
+// upgrade authentication lands this is a digest of the Authorization header,
+// falling back to the client IP.
+func (h *Handler) principalOf(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		sum := sha256.Sum256([]byte(auth))
+		return "auth:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + h.clientIP(r)
+}
+
+// allow applies the connection, principal and target-device limits to req.
+// It returns a CodeRateLimited response when any of them is exhausted.
+// Gateway-local methods only count against the connection and principal.
+func (c *client) allow(req *rpc.Request) *rpc.Response {
+	now := time.Now()
+	scope := "connection"
+	ok, wait := c.rate.take(now)
+	if ok {
+		scope = "principal"
+		ok, wait = c.limits.principal.take(c.principal, now)
+	}
+	if ok {
+		scope = "device"
+		ok, wait = c.limits.device.take(c.targetDevice(req), now)
+	}
+	if ok {
+		return nil
+	}
+	ms := wait.Milliseconds() + 1 // round up so retrying at the hint succeeds
+	return &rpc.Response{JSONRPC: "2.0", ID: req.ID, Error: &rpc.Error{Code: rpc.CodeRateLimited, Message: "rate limit exceeded", Data: map[string]any{"scope": scope, "retryAfterMs": ms}}}
+}
+
+// targetDevice returns the device req is routed to: the bound device, or the
+// params._target device on a multiplexed connection ("" when neither applies).
+func (c *client) targetDevice(req *rpc.Request) string {
+	if strings.HasPrefix(req.Method, gatewayMethodPrefix) {
+		return ""
+	}
+	if c.sess.scope.device != "" {
+		return strings.ToLower(c.sess.scope.device)
+	}
+	if t, _, err := extractTarget(req); err == nil && t != nil {
+		return strings.ToLower(normalizeDevice(t.Device, c.sess.scope.prefix))
+	}
+	return ""
+}
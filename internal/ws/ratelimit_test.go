@@ -0,0 +1,116 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    RateLimit
+		wantErr bool
+	}{
+		{"", RateLimit{}, false},
+		{"10", RateLimit{Rate: 10, Burst: 10}, false},
+		{"0.5", RateLimit{Rate: 0.5, Burst: 1}, false},
+		{"10:40", RateLimit{Rate: 10, Burst: 40}, false},
+		{"fast", RateLimit{}, true},
+		{"10:0", RateLimit{}, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRateLimit(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseRateLimit(%q) = %+v, %v; want %+v (err=%v)", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	l := RateLimit{Rate: 2, Burst: 2}
+	var b tokenBucket
+	now := time.Unix(0, 0)
+	for i := 0; i < 2; i++ {
+		if ok, _ := b.take(l, now); !ok {
+			t.Fatalf("burst token %d refused", i)
+		}
+	}
+	ok, wait := b.take(l, now)
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected refusal with 500ms wait, got ok=%v wait=%v", ok, wait)
+	}
+	if ok, _ := b.take(l, now.Add(500*time.Millisecond)); !ok {
+		t.Fatalf("token not refilled after wait")
+	}
+}
+
+func TestConnectionRateLimited(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}, ConnRate: RateLimit{Rate: 1, Burst: 2}})
+	for id := 1; id <= 3; id++ {
+		_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": id, "method": "Device.Ping"})
+	}
+	var limited *rpc.Response
+	for i := 0; i < 3; i++ {
+		if resp := readResponse(t, c); resp.Error != nil {
+			limited = &resp
+		}
+	}
+	if limited == nil || string(limited.ID) != "3" || limited.Error.Code != rpc.CodeRateLimited {
+		t.Fatalf("expected id 3 rate limited, got %+v", limited)
+	}
+	data, _ := limited.Error.Data.(map[string]any)
+	if data["scope"] != "connection" || data["retryAfterMs"].(float64) <= 0 {
+		t.Fatalf("unexpected error data: %+v", limited.Error.Data)
+	}
+}
+
+func TestRateLimitedNotificationGetsNoReply(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}, ConnRate: RateLimit{Rate: 0.01, Burst: 2}})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "Device.Note"}) // counts toward the limit
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Ping"})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": "Device.Note"}) // limited, unanswered
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "Device.Ping"})
+	byID := map[string]rpc.Response{}
+	for i := 0; i < 2; i++ {
+		resp := readResponse(t, c)
+		byID[string(resp.ID)] = resp
+	}
+	if resp, ok := byID["1"]; !ok || resp.Error != nil {
+		t.Fatalf("expected success for id 1, got %+v", byID)
+	}
+	if resp, ok := byID["2"]; !ok || resp.Error == nil || resp.Error.Code != rpc.CodeRateLimited {
+		t.Fatalf("expected id 2 rate limited, got %+v", byID)
+	}
+}
+
+func TestRateLimitCoversCancelAndGatewayMethods(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}, ConnRate: RateLimit{Rate: 0.01, Burst: 1}})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "method": cancelMethod, "params": map[string]any{"id": 9}}) // takes the only token
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "gateway.device.status"})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != rpc.CodeRateLimited {
+		t.Fatalf("expected gateway method rate limited, got %+v", resp)
+	}
+}
+
+func TestDeviceRateLimitSharedAcrossConnections(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}, DeviceRate: RateLimit{Rate: 0.1, Burst: 1}}
+	ping := func(id int, device string) map[string]any {
+		return map[string]any{"jsonrpc": "2.0", "id": id, "method": "Device.Ping", "params": map[string]any{targetParam: map[string]any{"device": device}}}
+	}
+	a := dialTest(t, h)
+	_ = a.WriteJSON(ping(1, "mac:AABBCCDDEEFF"))
+	if resp := readResponse(t, a); resp.Error != nil {
+		t.Fatalf("first request refused: %+v", resp.Error)
+	}
+	b := dialTest(t, h)
+	_ = b.WriteJSON(ping(2, "aabbccddeeff"))
+	if resp := readResponse(t, b); resp.Error == nil || resp.Error.Code != rpc.CodeRateLimited {
+		t.Fatalf("expected same device limited from another connection, got %+v", resp)
+	}
+	_ = b.WriteJSON(ping(3, "112233445566"))
+	if resp := readResponse(t, b); resp.Error != nil {
+		t.Fatalf("other device refused: %+v", resp.Error)
+	}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SessionInfo is a point-in-time view of one live WebSocket connection.
+type SessionInfo struct {
+	ID              string    `json:"id"` // opaque connection id; never the resume token
+	RemoteAddr      string    `json:"remote_addr"`
+	Device          string    `json:"device,omitempty"`
+	Service         string    `json:"service,omitempty"`
+	Dispatcher      string    `json:"dispatcher"`
+	Subprotocol     string    `json:"subprotocol,omitempty"`
+	ConnectedAt     time.Time `json:"connected_at"`
+	InFlight        int       `json:"in_flight"`
+	QueueDepth      int       `json:"queue_depth"`
+	EventsDelivered uint64    `json:"events_delivered"`
+	DroppedFrames   uint64    `json:"dropped_frames"`
+}
+
+// SessionFilter selects sessions by bound device (prefix-insensitive),
+// service and remote address; empty fields match everything.
+type SessionFilter struct {
+	Device     string
+	Service    string
+	RemoteAddr string
+}
+
+// Registry tracks the gateway's open WebSocket connections.
+type Registry struct {
+	mu      sync.RWMutex
+	clients map[string]*client
+
+	counts connCounts // connection-limit slots, reserved before upgrade
+}
+
+func newRegistry() *Registry {
+	return &Registry{clients: make(map[string]*client)}
+}
+
+func (reg *Registry) add(c *client) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.clients[c.id] = c
+}
+
+func (reg *Registry) remove(c *client) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.clients, c.id)
+}
+
+func (reg *Registry) snapshot() []*client {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	out := make([]*client, 0, len(reg.clients))
+	for _, c := range reg.clients {
+		out = append(out, c)
+	}
+	return out
+}
+
+// Len returns the number of open connections.
+func (reg *Registry) Len() int {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	return len(reg.clients)
+}
+
+// List returns the connections matching f, oldest first.
+func (reg *Registry) List(f SessionFilter) []SessionInfo {
+	reg.mu.RLock()
+	out := make([]SessionInfo, 0, len(reg.clients))
+	for _, c := range reg.clients {
+		if f.matches(c) {
+			out = append(out, c.info())
+		}
+	}
+	reg.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool { return out[i].ConnectedAt.Before(out[j].ConnectedAt) })
+	return out
+}
+
+// Disconnect closes connection id with a close frame carrying code and
+// reason. It reports whether the connection was found.
+func (reg *Registry) Disconnect(id string, code int, reason string) bool {
+	reg.mu.RLock()
+	c, ok := reg.clients[id]
+	reg.mu.RUnlock()
+	if ok {
+		c.closeWith(code, reason)
+	}
+	return ok
+}
+
+func (f SessionFilter) matches(c *client) bool {
+	if f.Device != "" && !strings.EqualFold(normalizeDevice(f.Device, c.sess.scope.prefix), c.sess.scope.device) {
+		return false
+	}
+	if f.Service != "" && !strings.EqualFold(f.Service, c.service) {
+		return false
+	}
+	if f.RemoteAddr != "" && !strings.HasPrefix(c.remoteAddr, f.RemoteAddr) {
+		return false
+	}
+	return true
+}
+
+func (c *client) info() SessionInfo {
+	return SessionInfo{
+		ID:              c.id,
+		RemoteAddr:      c.remoteAddr,
+		Device:          c.device,
+		Service:         c.service,
+		Dispatcher:      c.dispatcherType,
+		Subprotocol:     c.conn.Subprotocol(),
+		ConnectedAt:     c.connectedAt,
+		InFlight:        len(c.sem),
+		QueueDepth:      c.queue.depth(),
+		EventsDelivered: c.eventsDelivered.Load(),
+		DroppedFrames:   c.queue.droppedFrames(),
+	}
+}
@@ -0,0 +1,229 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+// defaultReverseTimeout bounds how long a device-initiated request waits for
+// the client's answer when Handler.ReverseTimeout is unset. Requests such as
+// user-confirmation prompts wait on a person, hence the generous default.
+const defaultReverseTimeout = 60 * time.Second
+
+// reverseSessionMarker in a WRP destination addresses one session, e.g.
+// "dns:blizzardgw/gateway/session/<token>".
+const reverseSessionMarker = "/session/"
+
+// reverseIDPrefix marks ids the gateway assigns to requests it sends clients.
+const reverseIDPrefix = "gw-"
+
+// reverseCalls tracks requests sent to the client and awaiting its response.
+type reverseCalls struct {
+	mu sync.Mutex
+	m  map[string]chan *rpc.Response
+}
+
+func (rc *reverseCalls) add(key string) chan *rpc.Response {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.m == nil {
+		rc.m = make(map[string]chan *rpc.Response)
+	}
+	ch := make(chan *rpc.Response, 1)
+	rc.m[key] = ch
+	return ch
+}
+
+func (rc *reverseCalls) remove(key string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	delete(rc.m, key)
+}
+
+// complete delivers resp to the waiting call; unknown or late ids are dropped.
+func (rc *reverseCalls) complete(resp *rpc.Response) {
+	key := idKey(resp.ID)
+	rc.mu.Lock()
+	ch, ok := rc.m[key]
+	delete(rc.m, key)
+	rc.mu.Unlock()
+	if ok {
+		ch <- resp
+	}
+}
+
+// callClient sends the client a JSON-RPC request under a gateway-assigned id
+// and waits for its response until ctx is done or the connection closes.
+func (c *client) callClient(ctx context.Context, method string, params json.RawMessage) *rpc.Response {
+	id, _ := json.Marshal(reverseIDPrefix + uuid.NewString())
+	ch := c.calls.add(idKey(id))
+	defer c.calls.remove(idKey(id))
+	c.writeJSON(rpc.Request{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	select {
+	case resp := <-ch:
+		return resp
+	case <-ctx.Done():
+		return &rpc.Response{JSONRPC: "2.0", Error: &rpc.Error{Code: rpc.CodeClientTimeout, Message: "client did not respond"}}
+	case <-c.ctx.Done():
+		return &rpc.Response{JSONRPC: "2.0", Error: &rpc.Error{Code: rpc.CodeNoClient, Message: "client disconnected"}}
+	}
+}
+
+// controller picks the client for a request from device: the client attached
+// to session when one is named (it must be unbound or bound to device),
+// otherwise the most recently connected client bound to device.
+func (h *Handler) controller(device, session string) *client {
+	if session != "" {
+		c := h.sessions().client(session)
+		if c == nil || (c.sess.scope.device != "" && !c.boundTo(device)) {
+			return nil
+		}
+		return c
+	}
+	var candidates []*client
+	for _, c := range h.Registry().snapshot() {
+		if c.sess.scope.device != "" && c.boundTo(device) && !c.isDraining() {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].connectedAt.After(candidates[j].connectedAt) })
+	return candidates[0]
+}
+
+func (c *client) isDraining() bool {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	return c.draining
+}
+
+// boundTo reports whether the connection is bound to device.
+func (c *client) boundTo(device string) bool {
+	return strings.EqualFold(normalizeDevice(device, c.sess.scope.prefix), c.sess.scope.device)
+}
+
+// ReverseHandler returns an http.HandlerFunc accepting device-initiated WRP
+// SimpleRequestResponse messages (msgpack, or JSON with a JSON content type)
+// whose payload is a JSON-RPC request. The request is forwarded to the
+// session named by a "/session/<token>" segment in the destination, or else
+// to the newest client bound to the source device, under a gateway-assigned
+// id. The client's response (with the device's id restored) is written back
+// as the WRP reply. Requests no client can take, or that time out, are
+// answered with a JSON-RPC error (CodeNoClient / CodeClientTimeout).
+//
+// Callers must send auth as their Authorization header. That credential is
+// the only control: the WRP source is taken as given, so whoever holds auth
+// can speak for any device, and only XMiDT should. An empty auth disables the
+// endpoint: every request is refused.
+func ReverseHandler(h *Handler, auth string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if auth == "" {
+			http.Error(w, "reverse requests disabled", http.StatusForbidden)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(auth)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		format := wrp.Msgpack
+		if strings.Contains(r.Header.Get("Content-Type"), "json") {
+			format = wrp.JSON
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 512*1024))
+		if err != nil {
+			http.Error(w, "read error", http.StatusBadRequest)
+			return
+		}
+		var msg wrp.Message
+		if err := wrp.NewDecoder(bytes.NewReader(body), format).Decode(&msg); err != nil || msg.Type != wrp.SimpleRequestResponseMessageType {
+			http.Error(w, "expected a WRP SimpleRequestResponse message", http.StatusBadRequest)
+			return
+		}
+		req, err := rpc.ParseRequest(msg.Payload)
+		if err != nil {
+			http.Error(w, "payload is not a JSON-RPC request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		source, _, _ := strings.Cut(msg.Source, "/")
+		device := deviceid.Normalize(source, "")
+		if device == "" {
+			http.Error(w, "missing source device", http.StatusBadRequest)
+			return
+		}
+		var session string
+		if i := strings.Index(msg.Destination, reverseSessionMarker); i >= 0 {
+			session, _, _ = strings.Cut(msg.Destination[i+len(reverseSessionMarker):], "/")
+		}
+
+		c := h.controller(device, session)
+		if req.IsNotification() {
+			// Nothing to wait for: deliver it and acknowledge.
+			if c == nil {
+				http.Error(w, "no client connected for device", http.StatusNotFound)
+				return
+			}
+			c.writeJSON(rpc.Request{JSONRPC: "2.0", Method: req.Method, Params: req.Params})
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		var resp *rpc.Response
+		if c == nil {
+			resp = &rpc.Response{Error: &rpc.Error{Code: rpc.CodeNoClient, Message: "no client connected for device", Data: device}}
+		} else {
+			timeout := h.ReverseTimeout
+			if timeout <= 0 {
+				timeout = defaultReverseTimeout
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			resp = c.callClient(ctx, req.Method, req.Params)
+		}
+		log.Printf("reverse request device=%s session=%s method=%s client_error=%v", device, session, req.Method, resp.Error != nil)
+		resp.JSONRPC, resp.ID = "2.0", req.ID
+		payload, err := json.Marshal(resp)
+		if err != nil {
+			http.Error(w, "marshal response failed", http.StatusInternalServerError)
+			return
+		}
+		reply := wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          msg.Destination,
+			Destination:     msg.Source,
+			TransactionUUID: msg.TransactionUUID,
+			ContentType:     "application/json",
+			Payload:         payload,
+		}
+		var out bytes.Buffer
+		if err := wrp.NewEncoder(&out, format).Encode(&reply); err != nil {
+			http.Error(w, "encode reply failed", http.StatusInternalServerError)
+			return
+		}
+		if format == wrp.JSON {
+			w.Header().Set("Content-Type", "application/json")
+		} else {
+			w.Header().Set("Content-Type", "application/msgpack")
+		}
+		_, _ = w.Write(out.Bytes())
+	}
+}
@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+	wrp "github.com/xmidt-org/wrp-go/v3"
+)
+
+const testReverseAuth = "Basic cmV2OnNlY3JldA=="
+
+// sendReverse posts a device request to the reverse endpoint as XMiDT would,
+// authenticated with auth.
+func sendReverse(t *testing.T, url, auth, source, dest, payload string) *http.Response {
+	t.Helper()
+	var body bytes.Buffer
+	msg := wrp.Message{Type: wrp.SimpleRequestResponseMessageType, Source: source, Destination: dest, TransactionUUID: "tx-1", Payload: []byte(payload)}
+	if err := wrp.NewEncoder(&body, wrp.Msgpack).Encode(&msg); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	req, _ := http.NewRequest(http.MethodPost, url, &body)
+	req.Header.Set("Content-Type", "application/msgpack")
+	req.Header.Set("Authorization", auth)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	return resp
+}
+
+// postReverse sends a device request from source to the reverse endpoint and
+// decodes the JSON-RPC response carried in the WRP reply.
+func postReverse(t *testing.T, url, source, dest, payload string) (wrp.Message, rpc.Response) {
+	t.Helper()
+	resp := sendReverse(t, url, testReverseAuth, source, dest, payload)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var reply wrp.Message
+	if err := wrp.NewDecoder(resp.Body, wrp.Msgpack).Decode(&reply); err != nil {
+		t.Fatalf("decode reply: %v", err)
+	}
+	var r rpc.Response
+	if err := json.Unmarshal(reply.Payload, &r); err != nil {
+		t.Fatalf("reply payload: %v", err)
+	}
+	return reply, r
+}
+
+func TestReverseRequestRoundTrip(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	c, _ := dialURL(t, "ws"+strings.TrimPrefix(srv.URL, "http")+"/ws/mac:112233445566/BlizzardRDK")
+	rev := httptest.NewServer(ReverseHandler(h, testReverseAuth))
+	defer rev.Close()
+
+	// The client answers the forwarded prompt under the gateway's id.
+	go func() {
+		_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var req rpc.Request
+		if err := c.ReadJSON(&req); err != nil || req.Method != "ui.confirm" {
+			return
+		}
+		_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": map[string]any{"accepted": true}})
+	}()
+
+	reply, resp := postReverse(t, rev.URL, "mac:112233445566/BlizzardRDK", "dns:blizzardgw/gateway", `{"jsonrpc":"2.0","id":7,"method":"ui.confirm","params":{"text":"Pair?"}}`)
+	if reply.Destination != "mac:112233445566/BlizzardRDK" || reply.TransactionUUID != "tx-1" {
+		t.Fatalf("reply not addressed back to the device: %+v", reply)
+	}
+	if resp.Error != nil || string(resp.ID) != "7" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if m, _ := resp.Result.(map[string]any); m["accepted"] != true {
+		t.Fatalf("unexpected result: %v", resp.Result)
+	}
+}
+
+func TestReverseRequestTargets(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}, ReverseTimeout: 100 * time.Millisecond}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	_, params := dialURL(t, wsURL+"/ws/mac:112233445566/BlizzardRDK")
+	token, _ := params["session"].(string)
+	rev := httptest.NewServer(ReverseHandler(h, testReverseAuth))
+	defer rev.Close()
+	req := `{"jsonrpc":"2.0","id":"q","method":"ui.confirm"}`
+
+	if _, resp := postReverse(t, rev.URL, "mac:aabbccddeeff", "dns:blizzardgw/gateway", req); resp.Error == nil || resp.Error.Code != rpc.CodeNoClient {
+		t.Fatalf("expected no-client error for unconnected device, got %+v", resp)
+	}
+	if _, resp := postReverse(t, rev.URL, "mac:aabbccddeeff", "dns:blizzardgw/gateway/session/"+token, req); resp.Error == nil || resp.Error.Code != rpc.CodeNoClient {
+		t.Fatalf("expected no-client error for a session bound to another device, got %+v", resp)
+	}
+	// The bound client never answers, so addressing its session times out.
+	if _, resp := postReverse(t, rev.URL, "mac:11-22-33-44-55-66", "dns:blizzardgw/gateway/session/"+token, req); resp.Error == nil || resp.Error.Code != rpc.CodeClientTimeout {
+		t.Fatalf("expected client timeout, got %+v", resp)
+	}
+}
+
+func TestReverseRequestAuth(t *testing.T) {
+	h := &Handler{Dispatcher: rpc.EchoDispatcher{}}
+	req := `{"jsonrpc":"2.0","id":1,"method":"ui.confirm"}`
+	rev := httptest.NewServer(ReverseHandler(h, testReverseAuth))
+	defer rev.Close()
+	disabled := httptest.NewServer(ReverseHandler(h, ""))
+	defer disabled.Close()
+
+	tests := []struct {
+		name   string
+		url    string
+		auth   string
+		source string
+		want   int
+	}{
+		{"disabled", disabled.URL, "", "mac:112233445566/BlizzardRDK", http.StatusForbidden},
+		{"no credentials", rev.URL, "", "mac:112233445566/BlizzardRDK", http.StatusUnauthorized},
+		{"wrong credentials", rev.URL, "Basic bm9wZQ==", "mac:112233445566/BlizzardRDK", http.StatusUnauthorized},
+		{"no source", rev.URL, testReverseAuth, "", http.StatusBadRequest},
+		{"authenticated", rev.URL, testReverseAuth, "mac:112233445566/BlizzardRDK", http.StatusOK},
+	}
+	for _, tt := range tests {
+		resp := sendReverse(t, tt.url, tt.auth, tt.source, "dns:blizzardgw/gateway", req)
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+)
+
+// Route says where requests for one device are sent upstream.
+type Route struct {
+	Prefix    string   `json:"prefix,omitempty"`    // WRP destination prefix, e.g. "mac:"
+	Service   string   `json:"service,omitempty"`   // canonical service the device registered with Parodus
+	Fallbacks []string `json:"fallbacks,omitempty"` // services tried after Service and the path alias
+}
+
+// RouteResolver maps a device (as named by the client) and the service from
+// the request path to its Route. An empty device yields the default route.
+type RouteResolver interface {
+	Resolve(device, service string) Route
+}
+
+// RouteTable is a RouteResolver with a default route and per-device-pattern
+// overrides. The first rule whose pattern matches the device wins; fields the
+// rule leaves empty are inherited from the default.
+type RouteTable struct {
+	Default Route       `json:"default"`
+	Rules   []RouteRule `json:"routes"`
+}
+
+// RouteRule overrides the default route for devices matching Match, a
+// case-insensitive glob (path.Match syntax) such as "serial:*".
+type RouteRule struct {
+	Match string `json:"match"`
+	Route
+}
+
+// Defaults used when neither the environment nor a route table sets them.
+const (
+	defaultDestPrefix = "mac:"
+	defaultService    = "BlizzardRDK"
+)
+
+// EnvRoutes builds a single-route table from DEST_PREFIX,
+// CANONICAL_SERVICE_NAME and DEST_SERVICE_FALLBACKS, read once.
+func EnvRoutes() *RouteTable {
+	rt := Route{Prefix: os.Getenv("DEST_PREFIX"), Service: os.Getenv("CANONICAL_SERVICE_NAME")}
+	if fb := os.Getenv("DEST_SERVICE_FALLBACKS"); fb != "" {
+		for _, p := range strings.Split(fb, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				rt.Fallbacks = append(rt.Fallbacks, p)
+			}
+		}
+	}
+	if rt.Prefix == "" {
+		rt.Prefix = defaultDestPrefix
+	}
+	if rt.Service == "" {
+		rt.Service = defaultService
+	}
+	return &RouteTable{Default: rt}
+}
+
+// LoadRouteTable reads a JSON routing table from file:
+//
+//	{"default": {"prefix": "mac:", "service": "BlizzardRDK"},
+//	 "routes": [{"match": "serial:*", "prefix": "serial:", "service": "BlizzardSerial", "fallbacks": ["Blizzard"]}]}
+//
+// Default fields left empty are taken from EnvRoutes.
+func LoadRouteTable(file string) (*RouteTable, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var t RouteTable
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("route table %s: %w", file, err)
+	}
+	for i, r := range t.Rules {
+		if r.Match == "" {
+			return nil, fmt.Errorf("route table %s: rule %d has no match pattern", file, i)
+		}
+		if _, err := path.Match(r.Match, ""); err != nil {
+			return nil, fmt.Errorf("route table %s: rule %d: %w", file, i, err)
+		}
+	}
+	t.Default = overlay(EnvRoutes().Default, t.Default)
+	return &t, nil
+}
+
+// Resolve implements RouteResolver. Rules are matched against the canonical
+// "scheme:value" form of device, bare ids taking the default route's scheme.
+func (t *RouteTable) Resolve(device, _ string) Route {
+	device = strings.ToLower(deviceid.Normalize(device, deviceid.SchemeOf(t.Default.Prefix)))
+	if device != "" {
+		for _, r := range t.Rules {
+			if ok, _ := path.Match(strings.ToLower(r.Match), device); ok {
+				return overlay(t.Default, r.Route)
+			}
+		}
+	}
+	return t.Default
+}
+
+// overlay returns base with the non-empty fields of o applied. A non-nil
+// empty Fallbacks clears the inherited list.
+func overlay(base, o Route) Route {
+	if o.Prefix != "" {
+		base.Prefix = o.Prefix
+	}
+	if o.Service != "" {
+		base.Service = o.Service
+	}
+	if o.Fallbacks != nil {
+		base.Fallbacks = o.Fallbacks
+	}
+	return base
+}
@@ -0,0 +1,66 @@
+package ws
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestRouteTableResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "routes.json")
+	table := `{
+		"default": {"fallbacks": ["Blizzard"]},
+		"routes": [
+			{"match": "serial:*", "prefix": "serial:", "service": "BlizzardSerial"},
+			{"match": "mac:AABB*", "fallbacks": []}
+		]
+	}`
+	if err := os.WriteFile(file, []byte(table), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rt, err := LoadRouteTable(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	tests := []struct {
+		device string
+		want   Route
+	}{
+		{"", Route{Prefix: "mac:", Service: "BlizzardRDK", Fallbacks: []string{"Blizzard"}}},
+		{"mac:112233445566", Route{Prefix: "mac:", Service: "BlizzardRDK", Fallbacks: []string{"Blizzard"}}},
+		{"SERIAL:X1234", Route{Prefix: "serial:", Service: "BlizzardSerial", Fallbacks: []string{"Blizzard"}}},
+		{"mac:aabbccddeeff", Route{Prefix: "mac:", Service: "BlizzardRDK", Fallbacks: []string{}}},
+		{"AA:BB:CC:DD:EE:FF", Route{Prefix: "mac:", Service: "BlizzardRDK", Fallbacks: []string{}}},
+	}
+	for _, tt := range tests {
+		if got := rt.Resolve(tt.device, "alias"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Resolve(%q) = %+v, want %+v", tt.device, got, tt.want)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte(`{"routes": [{"match": "[bad"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRouteTable(file); err == nil {
+		t.Fatalf("expected error for malformed pattern")
+	}
+}
+
+func TestBoundConnectionUsesRouteResolver(t *testing.T) {
+	srv, _ := newScytale(t)
+	h := &Handler{
+		Dispatcher: &rpc.WRPDispatcher{Client: &rpc.WRPClient{URL: srv.URL}, Source: "blizzard/gateway"},
+		Routes: &RouteTable{
+			Default: Route{Prefix: "mac:", Service: "BlizzardRDK"},
+			Rules:   []RouteRule{{Match: "serial:*", Route: Route{Prefix: "serial:", Service: "BlizzardSerial"}}},
+		},
+	}
+	c, _ := dialPath(t, h, "/ws/serial:X1234/Blizzard")
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "Device.Ping"})
+	if resp := readResponse(t, c); resp.Result != "serial:X1234/BlizzardSerial" {
+		t.Fatalf("unexpected destination: %+v", resp)
+	}
+}
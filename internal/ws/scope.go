@@ -0,0 +1,42 @@
+package ws
+
+import (
+	"strings"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/events"
+)
+
+// eventScope decides which bus events a connection receives. A connection
+// bound to a device (/ws/<device>/<service>) only sees that device's events;
+// an unbound connection sees nothing unless it opts in with ?events=all or
+// registers subscriptions via gateway.subscribe.
+type eventScope struct {
+	device string // bound device with DEST_PREFIX stripped ("" when unbound)
+	all    bool   // unbound connection opted into every device's events
+	prefix string // route prefix in effect when the connection was bound
+}
+
+// allows reports whether ev may be delivered on this connection.
+func (s eventScope) allows(ev events.Event) bool {
+	if s.device == "" {
+		return s.all
+	}
+	return strings.EqualFold(normalizeDevice(ev.Device, s.prefix), s.device)
+}
+
+// normalizeDevice canonicalizes a device id (see package deviceid) and drops
+// the scheme when it is prefix's, so "mac:AABBCCDDEEFF", "AA:BB:CC:DD:EE:FF"
+// and "aabbccddeeff" compare equal. Unparseable values (e.g. glob patterns)
+// only have an exact prefix stripped.
+func normalizeDevice(device, prefix string) string {
+	scheme := deviceid.SchemeOf(prefix)
+	id, err := deviceid.Parse(device, scheme)
+	if err != nil {
+		return strings.TrimPrefix(strings.TrimSpace(device), prefix)
+	}
+	if id.Scheme == scheme {
+		return id.Value
+	}
+	return id.String()
+}
@@ -0,0 +1,36 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+)
+
+func TestEventScope(t *testing.T) {
+	bound := eventScope{device: "112233445566", prefix: "mac:"}
+	unbound := eventScope{prefix: "mac:"}
+	optIn := eventScope{prefix: "mac:", all: true}
+
+	tests := []struct {
+		name   string
+		scope  eventScope
+		device string
+		want   bool
+	}{
+		{"bound prefixed match", bound, "mac:112233445566", true},
+		{"bound bare match", bound, "112233445566", true},
+		{"bound case-insensitive", eventScope{device: "aabbccddeeff", prefix: "mac:"}, "mac:AABBCCDDEEFF", true},
+		{"bound separated mac", eventScope{device: "aabbccddeeff", prefix: "mac:"}, "AA:BB:CC:DD:EE:FF", true},
+		{"bound other device", bound, "mac:aabbccddeeff", false},
+		{"bound empty device", bound, "", false},
+		{"unbound no opt-in", unbound, "mac:112233445566", false},
+		{"unbound opt-in", optIn, "mac:aabbccddeeff", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.scope.allows(events.Event{Device: tt.device}); got != tt.want {
+				t.Errorf("allows(%q) = %v, want %v", tt.device, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package ws
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/presence"
+)
+
+// Session defaults used when the corresponding Handler fields are unset.
+const (
+	defaultSessionBuffer    = 256
+	defaultMaxDetached      = 1024
+	defaultMaxDetachedPerIP = 16
+)
+
+// sessionMethod is the notification announcing the session token (and the
+// outcome of a resume attempt) as the first frame on every connection.
+const sessionMethod = "gateway.session"
+
+// session owns a connection's event stream. It stays subscribed to the bus
+// across reconnects, numbers every event notification with a monotonically
+// increasing seq, and keeps the most recent frames so a client reconnecting
+// with ?session=<id>&since=<seq> can replay what it missed.
+type session struct {
+	id    string
+	ip    string // client IP that opened it, for the detached limits
+	scope eventScope
+	subs  subscriptions // client-driven filters from gateway.subscribe
+	store *sessionStore
+	stop  func() // bus unsubscribe (nil without a bus)
+	// parked is the session's entry in store.detached while it awaits
+	// resumption; guarded by store.mu.
+	parked *list.Element
+
+	mu       sync.Mutex
+	seq      uint64
+	buf      []seqFrame // oldest first, at most store.size entries
+	attached *client
+	gen      uint64 // bumped on every detach; guards stale expiry timers
+}
+
+type seqFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// sessionStore holds sessions by id, including detached ones awaiting resume.
+// Each detached session keeps a bus subscription, a goroutine and its replay
+// buffer without holding a connection slot, so at most maxDetached of them
+// are kept, maxDetachedPerIP per client IP; the oldest is ended first.
+type sessionStore struct {
+	ttl              time.Duration // how long a detached session is kept (0 disables resumption)
+	size             int           // frames retained per session for replay
+	maxDetached      int
+	maxDetachedPerIP int
+	bus              *events.Bus
+
+	mu         sync.Mutex
+	sessions   map[string]*session
+	detached   list.List // of *session, oldest first
+	detachedBy map[string]int
+}
+
+func newSessionStore(bus *events.Bus, ttl time.Duration, size, maxDetached, maxDetachedPerIP int) *sessionStore {
+	if size <= 0 {
+		size = defaultSessionBuffer
+	}
+	if maxDetached <= 0 {
+		maxDetached = defaultMaxDetached
+	}
+	if maxDetachedPerIP <= 0 {
+		maxDetachedPerIP = defaultMaxDetachedPerIP
+	}
+	return &sessionStore{ttl: ttl, size: size, maxDetached: maxDetached, maxDetachedPerIP: maxDetachedPerIP, bus: bus,
+		sessions: make(map[string]*session), detachedBy: make(map[string]int)}
+}
+
+// open resumes session id when it exists and has the same event scope,
+// otherwise starts a new session for a client at ip. resumed reports which
+// happened.
+func (st *sessionStore) open(id string, scope eventScope, ip string) (s *session, resumed bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if id != "" {
+		if s, ok := st.sessions[id]; ok && s.scope == scope {
+			st.unpark(s)
+			return s, true
+		}
+		log.Printf("session resume rejected id=%s (unknown, expired or different binding)", id)
+	}
+	s = &session{id: uuid.NewString(), ip: ip, scope: scope, store: st}
+	if st.bus != nil {
+		_, ch, cancel := st.bus.Subscribe(64)
+		s.stop = cancel
+		go s.pump(ch)
+	}
+	st.sessions[s.id] = s
+	return s, false
+}
+
+// client returns the connection currently attached to session id, if any.
+func (st *sessionStore) client(id string) *client {
+	st.mu.Lock()
+	s, ok := st.sessions[id]
+	st.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attached
+}
+
+func (st *sessionStore) remove(s *session) {
+	st.mu.Lock()
+	delete(st.sessions, s.id)
+	st.unpark(s)
+	st.mu.Unlock()
+	if s.stop != nil {
+		s.stop()
+	}
+}
+
+// park records s as detached, then ends the oldest detached sessions of its
+// IP, and overall, beyond the limits.
+func (st *sessionStore) park(s *session) {
+	var evicted []*session
+	st.mu.Lock()
+	if _, ok := st.sessions[s.id]; !ok || s.parked != nil {
+		st.mu.Unlock()
+		return
+	}
+	s.parked = st.detached.PushBack(s)
+	st.detachedBy[s.ip]++
+	for e := st.detached.Front(); e != nil && st.detachedBy[s.ip] > st.maxDetachedPerIP; {
+		old := e.Value.(*session)
+		e = e.Next()
+		if old.ip == s.ip {
+			evicted = append(evicted, old)
+			delete(st.sessions, old.id)
+			st.unpark(old)
+		}
+	}
+	for st.detached.Len() > st.maxDetached {
+		old := st.detached.Front().Value.(*session)
+		evicted = append(evicted, old)
+		delete(st.sessions, old.id)
+		st.unpark(old)
+	}
+	st.mu.Unlock()
+	for _, old := range evicted {
+		log.Printf("session evicted id=%s ip=%s (too many detached sessions)", old.id, old.ip)
+		if old.stop != nil {
+			old.stop()
+		}
+	}
+}
+
+// unpark drops s from the detached sessions. st.mu must be held.
+func (st *sessionStore) unpark(s *session) {
+	if s.parked == nil {
+		return
+	}
+	st.detached.Remove(s.parked)
+	s.parked = nil
+	decrement(st.detachedBy, s.ip)
+}
+
+func (s *session) pump(ch <-chan events.Event) {
+	for ev := range ch {
+		s.deliver(ev)
+	}
+}
+
+// deliver publishes ev if the session's scope and subscriptions allow it.
+// Events for other devices never reach a bound session. With active
+// subscriptions only matching events are sent, wrapped with the subscription
+// id; otherwise the device's JSON-RPC payload is sent as-is. A bound session
+// always receives its device's presence notifications.
+func (s *session) deliver(ev events.Event) {
+	if s.scope.device != "" && !s.scope.allows(ev) {
+		return
+	}
+	if s.scope.device != "" && presence.IsStatusEvent(ev) {
+		s.publish(ev.Payload)
+		return
+	}
+	if s.subs.active() {
+		if sub := s.subs.match(ev, s.scope.prefix); sub != nil {
+			if data, err := json.Marshal(eventNotification(ev, sub)); err == nil {
+				s.publish(data)
+			}
+		}
+		return
+	}
+	if s.scope.allows(ev) {
+		s.publish(ev.Payload)
+	}
+}
+
+// publish numbers data, retains it for replay and forwards it to the
+// attached client, if any.
+func (s *session) publish(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	data = withSeq(data, s.seq)
+	s.buf = append(s.buf, seqFrame{seq: s.seq, data: data})
+	if len(s.buf) > s.store.size {
+		s.buf = s.buf[len(s.buf)-s.store.size:]
+	}
+	if s.attached != nil {
+		s.attached.writeEvent(data)
+	}
+}
+
+// attach binds c to the session and returns the buffered frames newer than
+// since, which the caller must send ahead of live delivery. gap reports that
+// frames after since were already evicted from the buffer. A client still
+// attached (e.g. a half-open socket) is closed.
+func (s *session) attach(c *client, since uint64) (last uint64, gap bool, replay [][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old := s.attached; old != nil && old != c {
+		_ = old.conn.Close()
+	}
+	s.attached = c
+	if s.seq > since && (len(s.buf) == 0 || s.buf[0].seq > since+1) {
+		gap = true
+	}
+	for _, f := range s.buf {
+		if f.seq > since {
+			replay = append(replay, f.data)
+		}
+	}
+	return s.seq, gap, replay
+}
+
+// detach unbinds c. The session is kept for the store's TTL so the client
+// can resume; without a TTL it ends immediately.
+func (s *session) detach(c *client) {
+	s.mu.Lock()
+	if s.attached != c {
+		s.mu.Unlock()
+		return
+	}
+	s.attached = nil
+	s.gen++
+	gen := s.gen
+	s.mu.Unlock()
+	if s.store.ttl <= 0 {
+		s.store.remove(s)
+		return
+	}
+	s.store.park(s)
+	time.AfterFunc(s.store.ttl, func() {
+		s.mu.Lock()
+		expired := s.attached == nil && s.gen == gen
+		s.mu.Unlock()
+		if expired {
+			s.store.remove(s)
+		}
+	})
+}
+
+// withSeq sets the top-level "seq" member of a JSON object frame, replacing
+// any the payload already has. Frames that are not JSON objects are returned
+// unchanged.
+func withSeq(data []byte, seq uint64) []byte {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil || obj == nil {
+		return data
+	}
+	obj["seq"], _ = json.Marshal(seq)
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return data
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+}
@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func publishN(bus *events.Bus, from, to int) {
+	for i := from; i <= to; i++ {
+		bus.Publish(events.Event{Device: "mac:112233445566", Name: "Tick", Payload: []byte(fmt.Sprintf(`{"jsonrpc":"2.0","method":"Tick","params":{"n":%d}}`, i))})
+	}
+}
+
+func readSeqs(t *testing.T, c interface{ ReadJSON(any) error }, n int) []float64 {
+	t.Helper()
+	var seqs []float64
+	for i := 0; i < n; i++ {
+		var msg map[string]any
+		if err := c.ReadJSON(&msg); err != nil {
+			t.Fatalf("read event %d: %v", i, err)
+		}
+		seq, _ := msg["seq"].(float64)
+		seqs = append(seqs, seq)
+	}
+	return seqs
+}
+
+func TestSessionResumeReplaysMissedEvents(t *testing.T) {
+	bus := events.NewBus()
+	srv := httptest.NewServer(&Handler{Dispatcher: rpc.EchoDispatcher{}, Bus: bus, SessionTTL: time.Minute})
+	defer srv.Close()
+	base := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/112233445566/BlizzardRDK"
+
+	c1, params := dialURL(t, base)
+	token, _ := params["session"].(string)
+	if token == "" || params["resumed"] != false {
+		t.Fatalf("unexpected session params %+v", params)
+	}
+	publishN(bus, 1, 2)
+	if got := readSeqs(t, c1, 2); got[0] != 1 || got[1] != 2 {
+		t.Fatalf("live seqs = %v", got)
+	}
+	c1.Close()
+	time.Sleep(50 * time.Millisecond) // let the server detach
+	publishN(bus, 3, 4)
+	time.Sleep(50 * time.Millisecond) // let the session buffer them
+
+	c2, params := dialURL(t, base+"?session="+token+"&since=2")
+	if params["session"] != token || params["resumed"] != true || params["gap"] != false {
+		t.Fatalf("resume not acknowledged: %+v", params)
+	}
+	_ = c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if got := readSeqs(t, c2, 2); got[0] != 3 || got[1] != 4 {
+		t.Fatalf("replayed seqs = %v, want [3 4]", got)
+	}
+	publishN(bus, 5, 5)
+	if got := readSeqs(t, c2, 1); got[0] != 5 {
+		t.Fatalf("live seq after replay = %v, want 5", got)
+	}
+}
+
+func TestSessionReplayLongerThanQueue(t *testing.T) {
+	for _, policy := range []OverflowPolicy{DropOldest, Disconnect} {
+		bus := events.NewBus()
+		srv := httptest.NewServer(&Handler{Dispatcher: rpc.EchoDispatcher{}, Bus: bus, SessionTTL: time.Minute, OverflowPolicy: policy})
+		base := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/112233445566/BlizzardRDK"
+		c1, params := dialURL(t, base)
+		token, _ := params["session"].(string)
+		c1.Close()
+		time.Sleep(50 * time.Millisecond) // let the server detach
+		// Paced: the bus drops events for a full subscriber.
+		for i := 1; i <= 150; i += 30 {
+			publishN(bus, i, i+29)
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		c2, params := dialURL(t, base+"?session="+token+"&since=0")
+		if params["resumed"] != true || params["gap"] != false || params["seq"] != float64(150) {
+			t.Fatalf("%s: unexpected session params %+v", policy, params)
+		}
+		_ = c2.SetReadDeadline(time.Now().Add(2 * time.Second))
+		for i, seq := range readSeqs(t, c2, 150) {
+			if seq != float64(i+1) {
+				t.Fatalf("%s: replayed seq %v at position %d", policy, seq, i)
+			}
+		}
+		srv.Close()
+	}
+}
+
+func TestSessionResumeUnknownToken(t *testing.T) {
+	_, params := dialPath(t, &Handler{Dispatcher: rpc.EchoDispatcher{}, SessionTTL: time.Minute}, "/ws?session=nope&since=3")
+	if params["resumed"] != false || params["session"] == "nope" {
+		t.Fatalf("expected a fresh session, got %+v", params)
+	}
+}
+
+func TestWithSeq(t *testing.T) {
+	cases := map[string]string{
+		`{"a":1}`:             `{"a":1,"seq":7}`,
+		` { } `:               `{"seq":7}`,
+		`{"seq":1,"a":"<b>"}`: `{"a":"<b>","seq":7}`,
+		`[1,2]`:               `[1,2]`,
+		`null`:                `null`,
+		`not json`:            `not json`,
+		`{"broken"`:           `{"broken"`,
+	}
+	for in, want := range cases {
+		if got := string(withSeq([]byte(in), 7)); got != want {
+			t.Errorf("withSeq(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestDetachedSessionsAreCapped(t *testing.T) {
+	st := newSessionStore(events.NewBus(), time.Minute, 0, 3, 2)
+	open := func(ip string) *session {
+		s, _ := st.open("", eventScope{}, ip)
+		c := &client{}
+		s.attach(c, 0)
+		s.detach(c)
+		return s
+	}
+	a1, a2, a3 := open("10.0.0.1"), open("10.0.0.1"), open("10.0.0.1")
+	if _, ok := st.open(a1.id, eventScope{}, ""); ok {
+		t.Fatal("oldest session of an IP over its limit was kept")
+	}
+	b1, b2 := open("10.0.0.2"), open("10.0.0.3")
+	if st.detached.Len() != 3 {
+		t.Fatalf("expected 3 detached sessions, got %d", st.detached.Len())
+	}
+	if _, ok := st.sessions[a2.id]; ok {
+		t.Fatal("oldest session over the total limit was kept")
+	}
+	for _, s := range []*session{a3, b1, b2} {
+		if _, ok := st.sessions[s.id]; !ok {
+			t.Fatalf("session %s evicted early", s.ip)
+		}
+	}
+	// Resuming takes a session off the detached list.
+	if _, ok := st.open(b1.id, eventScope{}, ""); !ok || st.detached.Len() != 2 {
+		t.Fatalf("resume did not reattach: %d detached", st.detached.Len())
+	}
+}
@@ -0,0 +1,204 @@
+package ws
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// subscriptionParams are the params accepted by gateway.subscribe. Each filter
+// is a glob (path.Match syntax) unless Regex is set; empty filters match all.
+type subscriptionParams struct {
+	Device  string `json:"device"`
+	Service string `json:"service"`
+	Event   string `json:"event"`
+	Regex   bool   `json:"regex"`
+}
+
+// subscription is one active client-driven event filter.
+type subscription struct {
+	id      string
+	device  matcher
+	service matcher
+	event   matcher
+}
+
+// matcher matches a single event field against a glob or regular expression.
+// fold matches case-insensitively (the glob is stored lower-cased).
+type matcher struct {
+	glob string
+	re   *regexp.Regexp
+	fold bool
+}
+
+func newMatcher(pattern string, regex bool) (matcher, error) {
+	if pattern == "" {
+		return matcher{}, nil
+	}
+	if regex {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return matcher{}, err
+		}
+		return matcher{re: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return matcher{}, err
+	}
+	return matcher{glob: pattern}, nil
+}
+
+// newDeviceMatcher compiles a device filter. The pattern is normalized once,
+// here, the way normalizeDevice treats event devices: an exact id is
+// canonicalized, and a glob or regex has the route prefix stripped and
+// matches case-insensitively, so "MAC:AABB*" still finds "mac:aabbcc…".
+func newDeviceMatcher(pattern, prefix string, regex bool) (matcher, error) {
+	if pattern == "" {
+		return matcher{}, nil
+	}
+	pattern = normalizeDevicePattern(pattern, prefix)
+	if regex {
+		return newMatcher("(?i)"+pattern, true)
+	}
+	m, err := newMatcher(strings.ToLower(pattern), false)
+	m.fold = true
+	return m, err
+}
+
+// normalizeDevicePattern is normalizeDevice for filters that may not parse as
+// a device id: those only lose a case-insensitive prefix match.
+func normalizeDevicePattern(pattern, prefix string) string {
+	if _, err := deviceid.Parse(pattern, deviceid.SchemeOf(prefix)); err == nil {
+		return normalizeDevice(pattern, prefix)
+	}
+	pattern = strings.TrimSpace(pattern)
+	if n := len(prefix); n > 0 && len(pattern) >= n && strings.EqualFold(pattern[:n], prefix) {
+		pattern = pattern[n:]
+	}
+	return pattern
+}
+
+func (m matcher) match(s string) bool {
+	switch {
+	case m.re != nil:
+		return m.re.MatchString(s)
+	case m.glob != "":
+		if m.fold {
+			s = strings.ToLower(s)
+		}
+		ok, _ := path.Match(m.glob, s)
+		return ok
+	}
+	return true
+}
+
+// subscriptions is the per-connection set of active filters.
+type subscriptions struct {
+	mu   sync.Mutex
+	list []*subscription
+}
+
+func (s *subscriptions) add(sub *subscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.list = append(s.list, sub)
+}
+
+func (s *subscriptions) remove(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sub := range s.list {
+		if sub.id == id {
+			s.list = append(s.list[:i], s.list[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// active reports whether any subscription exists; when none do the
+// connection falls back to its default event scope.
+func (s *subscriptions) active() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.list) > 0
+}
+
+// match returns the first subscription accepting ev (device compared after
+// prefix normalization), or nil.
+func (s *subscriptions) match(ev events.Event, prefix string) *subscription {
+	device := normalizeDevice(ev.Device, prefix)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sub := range s.list {
+		if sub.device.match(device) && sub.service.match(ev.Service) && sub.event.match(ev.Name) {
+			return sub
+		}
+	}
+	return nil
+}
+
+// subscribe implements gateway.subscribe.
+func (c *client) subscribe(r *rpc.Request) *rpc.Response {
+	var p subscriptionParams
+	if len(r.Params) > 0 {
+		if err := json.Unmarshal(r.Params, &p); err != nil {
+			return invalidParams(r, err.Error())
+		}
+	}
+	sub := &subscription{id: uuid.NewString()}
+	var err error
+	if sub.device, err = newDeviceMatcher(p.Device, c.sess.scope.prefix, p.Regex); err != nil {
+		return invalidParams(r, fmt.Sprintf("device: %v", err))
+	}
+	if sub.service, err = newMatcher(p.Service, p.Regex); err != nil {
+		return invalidParams(r, fmt.Sprintf("service: %v", err))
+	}
+	if sub.event, err = newMatcher(p.Event, p.Regex); err != nil {
+		return invalidParams(r, fmt.Sprintf("event: %v", err))
+	}
+	c.sess.subs.add(sub)
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: map[string]any{"subscription": sub.id}}
+}
+
+// unsubscribe implements gateway.unsubscribe.
+func (c *client) unsubscribe(r *rpc.Request) *rpc.Response {
+	var p struct {
+		Subscription string `json:"subscription"`
+	}
+	if err := json.Unmarshal(r.Params, &p); err != nil || p.Subscription == "" {
+		return invalidParams(r, "subscription id required")
+	}
+	if !c.sess.subs.remove(p.Subscription) {
+		return invalidParams(r, "unknown subscription")
+	}
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: true}
+}
+
+// eventNotification wraps ev for delivery under a subscription, echoing the
+// subscription id so clients can demultiplex.
+func eventNotification(ev events.Event, sub *subscription) rpc.Notification {
+	var payload any = json.RawMessage(ev.Payload)
+	if !json.Valid(ev.Payload) {
+		payload = string(ev.Payload)
+	}
+	return rpc.Notification{JSONRPC: "2.0", Method: buildEventMethod(ev), Params: map[string]any{
+		"subscription": sub.id,
+		"device":       ev.Device,
+		"service":      ev.Service,
+		"event":        ev.Name,
+		"payload":      payload,
+	}}
+}
+
+func invalidParams(r *rpc.Request, detail string) *rpc.Response {
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Error: &rpc.Error{Code: -32602, Message: "invalid params", Data: detail}}
+}
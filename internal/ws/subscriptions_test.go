@@ -0,0 +1,96 @@
+package ws
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/events"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestGatewaySubscribe(t *testing.T) {
+	bus := events.NewBus()
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}, Bus: bus})
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "gateway.subscribe", "params": map[string]any{"device": "mac:1122*", "event": "Time.*"}})
+	resp := readResponse(t, c)
+	if resp.Error != nil {
+		t.Fatalf("subscribe failed: %+v", resp.Error)
+	}
+	subID, _ := resp.Result.(map[string]any)["subscription"].(string)
+	if subID == "" {
+		t.Fatalf("missing subscription id in %+v", resp.Result)
+	}
+
+	bus.Publish(events.Event{Device: "mac:aabbccddeeff", Service: "BlizzardRDK", Name: "Time.TimerElapsed", Payload: []byte(`{}`)})
+	bus.Publish(events.Event{Device: "mac:112233445566", Service: "BlizzardRDK", Name: "Power.Changed", Payload: []byte(`{}`)})
+	bus.Publish(events.Event{Device: "mac:112233445566", Service: "BlizzardRDK", Name: "Time.TimerElapsed", Payload: []byte(`{"n":1}`)})
+
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var note struct {
+		Method string `json:"method"`
+		Params struct {
+			Subscription string          `json:"subscription"`
+			Event        string          `json:"event"`
+			Payload      json.RawMessage `json:"payload"`
+		} `json:"params"`
+	}
+	if err := c.ReadJSON(&note); err != nil {
+		t.Fatalf("read notification: %v", err)
+	}
+	if note.Params.Subscription != subID || note.Params.Event != "Time.TimerElapsed" || string(note.Params.Payload) != `{"n":1}` {
+		t.Fatalf("unexpected notification: %+v", note)
+	}
+	if note.Method != "rpc.Event.BlizzardRDK.Time.TimerElapsed" {
+		t.Fatalf("unexpected method %q", note.Method)
+	}
+
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "gateway.unsubscribe", "params": map[string]any{"subscription": subID}})
+	if resp := readResponse(t, c); resp.Error != nil || resp.Result != true {
+		t.Fatalf("unsubscribe failed: %+v", resp)
+	}
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 3, "method": "gateway.unsubscribe", "params": map[string]any{"subscription": subID}})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("expected invalid params for unknown subscription, got %+v", resp)
+	}
+}
+
+func TestGatewayMethodsNotForwarded(t *testing.T) {
+	c := dialTest(t, &Handler{Dispatcher: rpc.EchoDispatcher{}})
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 1, "method": "gateway.nope"})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32601 {
+		t.Fatalf("expected method not found, got %+v", resp)
+	}
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": 2, "method": "gateway.subscribe", "params": map[string]any{"event": "[", "regex": false}})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("expected invalid params for bad glob, got %+v", resp)
+	}
+}
+
+func TestDeviceMatcherNormalizesPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		regex   bool
+		device  string
+		want    bool
+	}{
+		{"MAC:AABB*", "mac:", false, "mac:aabbccddeeff", true},
+		{"MAC:AABB*", "", false, "mac:aabbccddeeff", true},
+		{"AA:BB:CC:DD:EE:FF", "mac:", false, "mac:aabbccddeeff", true},
+		{"aabbccddeeff", "mac:", false, "MAC:AA-BB-CC-DD-EE-FF", true},
+		{"serial:ab*", "", false, "serial:ABC1", true},
+		{"^AABB", "mac:", true, "mac:aabbccddeeff", true},
+		{"MAC:1122*", "mac:", false, "mac:aabbccddeeff", false},
+	}
+	for _, tt := range tests {
+		m, err := newDeviceMatcher(tt.pattern, tt.prefix, tt.regex)
+		if err != nil {
+			t.Fatalf("newDeviceMatcher(%q): %v", tt.pattern, err)
+		}
+		if got := m.match(normalizeDevice(tt.device, tt.prefix)); got != tt.want {
+			t.Errorf("pattern %q (prefix %q) vs %q = %v, want %v", tt.pattern, tt.prefix, tt.device, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/deviceid"
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+// timeoutParam is the reserved params member by which a client asks for its
+// own deadline, in milliseconds. It is capped by policy and stripped before
+// the request is forwarded.
+const timeoutParam = "_timeoutMs"
+
+// defaultRPCTimeout bounds upstream calls no timeout rule covers.
+const defaultRPCTimeout = 8 * time.Second
+
+// TimeoutPolicy decides how long an upstream call may take. The first rule
+// matching the request's method, device and service sets the timeout;
+// otherwise Default applies. A client-requested deadline (params._timeoutMs)
+// replaces it up to the rule's Max, else the policy's Max, else the timeout
+// itself (clients may then only shorten it).
+type TimeoutPolicy struct {
+	Default time.Duration
+	Max     time.Duration
+	Rules   []TimeoutRule
+}
+
+// TimeoutRule matches requests by glob (path.Match syntax); empty patterns
+// match everything. Device patterns see the canonical "scheme:value" id and
+// service patterns either the requested or the canonical service.
+type TimeoutRule struct {
+	Method  string
+	Device  string
+	Service string
+	Timeout time.Duration
+	Max     time.Duration
+}
+
+// LoadTimeoutPolicy reads a JSON timeout policy from file:
+//
+//	{"default": "8s", "max": "60s",
+//	 "rules": [{"method": "Firmware.Download*", "timeout": "120s"},
+//	           {"method": "*.Ping", "timeout": "2s"},
+//	           {"method": "Device.CheckFirmware", "device": "serial:*", "timeout": "30s", "max": "90s"}]}
+func LoadTimeoutPolicy(file string) (*TimeoutPolicy, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var raw struct {
+		Default string `json:"default"`
+		Max     string `json:"max"`
+		Rules   []struct {
+			Method  string `json:"method"`
+			Device  string `json:"device"`
+			Service string `json:"service"`
+			Timeout string `json:"timeout"`
+			Max     string `json:"max"`
+		} `json:"rules"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("timeout policy %s: %w", file, err)
+	}
+	duration := func(what, s string) (time.Duration, error) {
+		if s == "" {
+			return 0, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			return 0, fmt.Errorf("timeout policy %s: %s: invalid duration %q", file, what, s)
+		}
+		return d, nil
+	}
+	p := &TimeoutPolicy{}
+	if p.Default, err = duration("default", raw.Default); err != nil {
+		return nil, err
+	}
+	if p.Max, err = duration("max", raw.Max); err != nil {
+		return nil, err
+	}
+	for i, r := range raw.Rules {
+		rule := TimeoutRule{Method: r.Method, Device: r.Device, Service: r.Service}
+		what := fmt.Sprintf("rule %d", i)
+		if rule.Timeout, err = duration(what+" timeout", r.Timeout); err != nil {
+			return nil, err
+		}
+		if rule.Timeout == 0 {
+			return nil, fmt.Errorf("timeout policy %s: %s has no timeout", file, what)
+		}
+		if rule.Max, err = duration(what+" max", r.Max); err != nil {
+			return nil, err
+		}
+		for _, pat := range []string{r.Method, r.Device, r.Service} {
+			if _, err := path.Match(pat, ""); err != nil {
+				return nil, fmt.Errorf("timeout policy %s: %s: %w", file, what, err)
+			}
+		}
+		p.Rules = append(p.Rules, rule)
+	}
+	return p, nil
+}
+
+// Resolve returns the timeout for a call and the cap on client-requested
+// deadlines. device is canonical; services lists the names the call is known
+// by (requested and canonical).
+func (p *TimeoutPolicy) Resolve(method, device string, services ...string) (timeout, max time.Duration) {
+	timeout, max, _ = p.resolve(method, device, services)
+	return timeout, max
+}
+
+// resolve is Resolve, also reporting whether a rule (rather than the
+// default) decided the timeout.
+func (p *TimeoutPolicy) resolve(method, device string, services []string) (timeout, max time.Duration, ruled bool) {
+	timeout, max = defaultRPCTimeout, 0
+	if p == nil {
+		return timeout, timeout, false
+	}
+	if p.Default > 0 {
+		timeout = p.Default
+	}
+	max = p.Max
+	for _, r := range p.Rules {
+		if globMatch(r.Method, method) && globMatch(strings.ToLower(r.Device), strings.ToLower(device)) && anyGlobMatch(r.Service, services) {
+			timeout = r.Timeout
+			if r.Max > 0 {
+				max = r.Max
+			}
+			ruled = true
+			break
+		}
+	}
+	if max < timeout {
+		max = timeout
+	}
+	return timeout, max, ruled
+}
+
+func globMatch(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
+
+func anyGlobMatch(pattern string, ss []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, s := range ss {
+		if globMatch(pattern, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// withTimeout bounds ctx by the policy timeout for r to device/service (or a
+// client-requested one, capped) and returns r with the reserved member
+// stripped. Where no rule covers the call, a timeout derived from the
+// device's observed latency for the method (h.Latency) replaces the default.
+func (h *Handler) withTimeout(ctx context.Context, r *rpc.Request, device, service string) (context.Context, context.CancelFunc, *rpc.Request, error) {
+	raw, out, err := takeParam(r, timeoutParam)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	services := []string{service}
+	if device != "" {
+		route := h.routes().Resolve(device, service)
+		device = deviceid.Normalize(device, deviceid.SchemeOf(route.Prefix))
+		services = append(services, route.Service)
+	}
+	timeout, max, ruled := h.Timeouts.resolve(r.Method, device, services)
+	if !ruled {
+		if d, ok := h.Latency.Timeout(device, r.Method); ok {
+			timeout = d
+			if max < d {
+				max = d
+			}
+		}
+	}
+	if raw != nil {
+		var ms float64
+		if err := json.Unmarshal(raw, &ms); err != nil || ms <= 0 {
+			return nil, nil, nil, fmt.Errorf("%s must be a positive number of milliseconds", timeoutParam)
+		}
+		timeout = max
+		if ms < float64(max)/float64(time.Millisecond) {
+			timeout = time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, out, nil
+}
@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stepherg/blizzardgw/internal/rpc"
+)
+
+func TestTimeoutPolicyResolve(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "timeouts.json")
+	policy := `{"default": "5s", "max": "30s", "rules": [
+		{"method": "Firmware.Download*", "timeout": "120s"},
+		{"method": "*.Ping", "timeout": "2s"},
+		{"method": "Device.CheckFirmware", "device": "serial:*", "timeout": "25s", "max": "90s"},
+		{"service": "Legacy", "timeout": "15s"}
+	]}`
+	if err := os.WriteFile(file, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadTimeoutPolicy(file)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	tests := []struct {
+		method, device string
+		services       []string
+		timeout, max   time.Duration
+	}{
+		{"Firmware.DownloadImage", "mac:112233445566", []string{"BlizzardRDK"}, 120 * time.Second, 120 * time.Second},
+		{"Device.Ping", "mac:112233445566", nil, 2 * time.Second, 30 * time.Second},
+		{"Device.CheckFirmware", "SERIAL:X1", nil, 25 * time.Second, 90 * time.Second},
+		{"Device.CheckFirmware", "mac:112233445566", nil, 5 * time.Second, 30 * time.Second},
+		{"Device.Info", "mac:112233445566", []string{"Legacy", "BlizzardRDK"}, 15 * time.Second, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		if timeout, max := p.Resolve(tt.method, tt.device, tt.services...); timeout != tt.timeout || max != tt.max {
+			t.Errorf("Resolve(%s, %s) = %s, %s; want %s, %s", tt.method, tt.device, timeout, max, tt.timeout, tt.max)
+		}
+	}
+	if timeout, max := (*TimeoutPolicy)(nil).Resolve("Device.Ping", ""); timeout != defaultRPCTimeout || max != defaultRPCTimeout {
+		t.Errorf("nil policy = %s, %s", timeout, max)
+	}
+
+	if err := os.WriteFile(file, []byte(`{"rules": [{"method": "x", "timeout": "soon"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadTimeoutPolicy(file); err == nil {
+		t.Fatal("expected error for invalid duration")
+	}
+}
+
+// deadlineDispatcher answers with the time left on the request's deadline
+// (in whole seconds) and the params it received.
+type deadlineDispatcher struct{}
+
+func (deadlineDispatcher) Handle(ctx context.Context, r *rpc.Request) *rpc.Response {
+	dl, _ := ctx.Deadline()
+	return &rpc.Response{JSONRPC: "2.0", ID: r.ID, Result: map[string]any{"secs": time.Until(dl).Round(time.Second).Seconds(), "params": string(r.Params)}}
+}
+
+func TestTimeoutPolicyApplied(t *testing.T) {
+	h := &Handler{Dispatcher: deadlineDispatcher{}, Timeouts: &TimeoutPolicy{
+		Default: 5 * time.Second,
+		Rules:   []TimeoutRule{{Method: "Firmware.*", Timeout: 20 * time.Second, Max: 60 * time.Second}},
+	}}
+	c, _ := dialPath(t, h, "/ws/mac:112233445566/BlizzardRDK")
+	tests := []struct {
+		method string
+		params map[string]any
+		secs   float64
+		fwd    string
+	}{
+		{"Device.Info", nil, 5, "null"},
+		{"Firmware.Check", map[string]any{"full": true}, 20, `{"full":true}`},
+		{"Firmware.Check", map[string]any{"_timeoutMs": 45000}, 45, ""},
+		{"Firmware.Check", map[string]any{"_timeoutMs": 600000}, 60, ""},
+		{"Device.Info", map[string]any{"_timeoutMs": 600000}, 5, ""},
+	}
+	for i, tt := range tests {
+		_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": i, "method": tt.method, "params": tt.params})
+		resp := readResponse(t, c)
+		m, _ := resp.Result.(map[string]any)
+		if m["secs"] != tt.secs || m["params"] != tt.fwd {
+			t.Errorf("%s %v: got %v, want %vs with params %q", tt.method, tt.params, m, tt.secs, tt.fwd)
+		}
+	}
+	_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": "bad", "method": "Device.Info", "params": map[string]any{"_timeoutMs": -1}})
+	if resp := readResponse(t, c); resp.Error == nil || resp.Error.Code != -32602 {
+		t.Fatalf("expected invalid params for negative timeout, got %+v", resp)
+	}
+}
+
+func TestAdaptiveTimeout(t *testing.T) {
+	lt := &rpc.LatencyTracker{MinSamples: 5, Multiplier: 3, Floor: time.Second, Ceiling: 30 * time.Second}
+	for i := 0; i < 5; i++ {
+		lt.Observe("mac:112233445566", "Device.Info", 4*time.Second)
+		lt.Observe("mac:aabbccddeeff", "Device.Info", 10*time.Millisecond)
+		lt.Observe("mac:112233445566", "Firmware.Check", 4*time.Second)
+	}
+	h := &Handler{Dispatcher: deadlineDispatcher{}, Latency: lt, Timeouts: &TimeoutPolicy{
+		Default: 5 * time.Second,
+		Rules:   []TimeoutRule{{Method: "Firmware.*", Timeout: 20 * time.Second}},
+	}}
+	tests := []struct {
+		path, method string
+		secs         float64
+	}{
+		{"/ws/mac:11-22-33-44-55-66/BlizzardRDK", "Device.Info", 12}, // slow device: 3 × 4s
+		{"/ws/mac:aabbccddeeff/BlizzardRDK", "Device.Info", 1},       // fast device: floor
+		{"/ws/mac:112233445566/BlizzardRDK", "Device.Reboot", 5},     // no samples: default
+		{"/ws/mac:112233445566/BlizzardRDK", "Firmware.Check", 20},   // a rule wins
+	}
+	for i, tt := range tests {
+		c, _ := dialPath(t, h, tt.path)
+		_ = c.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": i, "method": tt.method})
+		resp := readResponse(t, c)
+		if m, _ := resp.Result.(map[string]any); m["secs"] != tt.secs {
+			t.Errorf("%s %s: got %v, want %vs", tt.path, tt.method, m, tt.secs)
+		}
+	}
+}